@@ -1,6 +1,7 @@
 package akashicpay
 
 type acNode struct {
+	Name     string
 	Minigate string
 	Node     string
 }
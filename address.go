@@ -0,0 +1,235 @@
+package akashicpay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Version byte of Tron addresses
+const tronAddressPrefix = 0x41
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// addressFamily groups networks that share an address format
+type addressFamily int
+
+const (
+	addressFamilyUnknown addressFamily = iota
+	addressFamilyEvm
+	addressFamilyTron
+	addressFamilySolana
+)
+
+func addressFamilyOf(network NetworkSymbol) addressFamily {
+	switch network {
+	case Ethereum_Mainnet, Ethereum_Sepolia, Binance_Smart_Chain_Mainnet, Binance_Smart_Chain_Testnet:
+		return addressFamilyEvm
+	case Tron, Tron_Shasta:
+		return addressFamilyTron
+	case Solana, Solana_Devnet:
+		return addressFamilySolana
+	default:
+		return addressFamilyUnknown
+	}
+}
+
+// ValidateAddress checks that addr is a valid L1 address on network,
+// including its checksum:
+//
+//   - ETH and BNB: 0x-prefixed hex. Mixed-case addresses must match their
+//     EIP-55 checksum, all-lowercase and all-uppercase ones carry none
+//   - TRX: base58check with the 0x41 version byte. Convert hex addresses
+//     with TronHexToBase58 first
+//   - SOL: base58 encoding 32 bytes
+//
+// Returns an *AkashicError with AkashicErrorCodeInvalidAddress if it isn't
+func ValidateAddress(network NetworkSymbol, addr string) error {
+	_, err := NormalizeAddress(network, addr)
+	return err
+}
+
+// NormalizeAddress validates addr like ValidateAddress and returns it in
+// its canonical form: EIP-55 checksummed for ETH and BNB, unchanged for TRX
+// and SOL
+func NormalizeAddress(network NetworkSymbol, addr string) (string, error) {
+	switch addressFamilyOf(network) {
+	case addressFamilyEvm:
+		return normalizeEvmAddress(addr)
+	case addressFamilyTron:
+		if _, err := decodeTronAddress(addr); err != nil {
+			return "", err
+		}
+		return addr, nil
+	case addressFamilySolana:
+		decoded, err := base58Decode(addr)
+		if err != nil {
+			return "", invalidAddressError(err.Error())
+		}
+		if len(decoded) != 32 {
+			return "", invalidAddressError(fmt.Sprintf("decodes to %d bytes instead of 32", len(decoded)))
+		}
+		return addr, nil
+	default:
+		return "", invalidAddressError(fmt.Sprintf("unsupported network %v", network))
+	}
+}
+
+// ChecksumEthAddress returns addr, an ETH or BNB address in any casing,
+// with EIP-55 checksum casing
+func ChecksumEthAddress(addr string) (string, error) {
+	raw, ok := strings.CutPrefix(addr, "0x")
+	if !ok || len(raw) != 40 {
+		return "", invalidAddressError("must be 0x followed by 40 hex characters")
+	}
+	if _, err := hex.DecodeString(raw); err != nil {
+		return "", invalidAddressError("must be 0x followed by 40 hex characters")
+	}
+	return "0x" + eip55(strings.ToLower(raw)), nil
+}
+
+// TronHexToBase58 converts a Tron address from hex, with the 41 version byte
+// or 0x instead of it, to base58check
+func TronHexToBase58(addr string) (string, error) {
+	if !isTronHexAddress(addr) {
+		return "", invalidAddressError("must be 41 or 0x followed by 40 hex characters")
+	}
+	payload, err := hex.DecodeString(addr[2:])
+	if err != nil {
+		return "", invalidAddressError("must be 41 or 0x followed by 40 hex characters")
+	}
+	payload = append([]byte{tronAddressPrefix}, payload...)
+	checksum := doubleSha256(payload)
+	return base58Encode(append(payload, checksum[:4]...)), nil
+}
+
+// TronBase58ToHex converts a base58check Tron address to hex with the 41
+// version byte
+func TronBase58ToHex(addr string) (string, error) {
+	payload, err := decodeTronAddress(addr)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(payload), nil
+}
+
+func normalizeEvmAddress(addr string) (string, error) {
+	checksummed, err := ChecksumEthAddress(addr)
+	if err != nil {
+		return "", err
+	}
+	raw := addr[2:]
+	if raw != strings.ToLower(raw) && raw != strings.ToUpper(raw) && addr != checksummed {
+		return "", invalidAddressError("EIP-55 checksum mismatch")
+	}
+	return checksummed, nil
+}
+
+// eip55 applies checksum casing to a lowercase hex address without 0x
+func eip55(lower string) string {
+	hash := keccak256([]byte(lower))
+	result := []byte(lower)
+	for i, c := range result {
+		nibble := hash[i/2] >> 4
+		if i%2 == 1 {
+			nibble = hash[i/2] & 0x0f
+		}
+		if c >= 'a' && nibble >= 8 {
+			result[i] = c - 'a' + 'A'
+		}
+	}
+	return string(result)
+}
+
+// keccak256 returns the legacy Keccak-256 hash of data, as used by Ethereum.
+// This is not SHA3-256, which pads differently
+func keccak256(data []byte) [32]byte {
+	var hash [32]byte
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	h.Sum(hash[:0])
+	return hash
+}
+
+func isTronHexAddress(addr string) bool {
+	return len(addr) == 42 && (strings.HasPrefix(addr, "41") || strings.HasPrefix(addr, "0x"))
+}
+
+// decodeTronAddress checks a base58check Tron address and returns its
+// payload, the version byte and 20 address bytes
+func decodeTronAddress(addr string) ([]byte, error) {
+	decoded, err := base58Decode(addr)
+	if err != nil {
+		return nil, invalidAddressError(err.Error())
+	}
+	if len(decoded) != 25 {
+		return nil, invalidAddressError(fmt.Sprintf("decodes to %d bytes instead of 25", len(decoded)))
+	}
+	payload, checksum := decoded[:21], decoded[21:]
+	if payload[0] != tronAddressPrefix {
+		return nil, invalidAddressError(fmt.Sprintf("version byte is 0x%02x instead of 0x41", payload[0]))
+	}
+	expected := doubleSha256(payload)
+	if !bytes.Equal(checksum, expected[:4]) {
+		return nil, invalidAddressError("base58check checksum mismatch")
+	}
+	return payload, nil
+}
+
+func doubleSha256(data []byte) [32]byte {
+	first := sha256.Sum256(data)
+	return sha256.Sum256(first[:])
+}
+
+func invalidAddressError(reason string) error {
+	return newAkashicError(AkashicErrorCodeInvalidAddress, "invalid address: "+reason)
+}
+
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var encoded []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	// Leading zero bytes are encoded as leading 1s
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("empty base58 string")
+	}
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range []byte(s) {
+		digit := strings.IndexByte(base58Alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
@@ -0,0 +1,121 @@
+package akashicpay
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeccak256(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"abc", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{"The quick brown fox jumps over the lazy dog", "4d741b6f1eb29cb2a9b9911c82f56fa8d73b04959d3d9d222895df6c0b28aa15"},
+		// Around the 136 byte block size
+		{strings.Repeat("a", 135), "34367dc248bbd832f4e3e69dfaac2f92638bd0bbd18f2912ba4ef454919cf446"},
+		{strings.Repeat("a", 136), "a6c4d403279fe3e0af03729caada8374b5ca54d8065329a3ebcaeb4b60aa386e"},
+		{strings.Repeat("a", 137), "d869f639c7046b4929fc92a4d988a8b22c55fbadb802c0c66ebcd484f1915f39"},
+		{strings.Repeat("a", 300), "5b7e0e47a96f32a88b4f14ca177982790807c40e1a105742ba0fc1babe1ef826"},
+	}
+	for _, tt := range tests {
+		hash := keccak256([]byte(tt.input))
+		if got := hex.EncodeToString(hash[:]); got != tt.want {
+			t.Errorf("keccak256(%d bytes) = %v, want %v", len(tt.input), got, tt.want)
+		}
+	}
+}
+
+func TestChecksumEthAddress(t *testing.T) {
+	// Test vectors of EIP-55
+	vectors := []string{
+		"0x52908400098527886E0F7030069857D2E4169EE7",
+		"0x8617E340B3D01FA5F11F306F4090FD50E238070D",
+		"0xde709f2102306220921060314715629080e2fb77",
+		"0x27b1fdb04752bbc536007a920d24acb045561c26",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	}
+	for _, want := range vectors[4:] {
+		got, err := ChecksumEthAddress("0x" + strings.ToLower(want[2:]))
+		if err != nil || got != want {
+			t.Errorf("ChecksumEthAddress() = %v, %v, want %v", got, err, want)
+		}
+	}
+	for _, addr := range vectors {
+		if err := ValidateAddress(Ethereum_Mainnet, addr); err != nil {
+			t.Errorf("ValidateAddress(%v) = %v, want nil", addr, err)
+		}
+	}
+}
+
+func TestTronAddressConversion(t *testing.T) {
+	// USDT on Tron
+	const base58 = "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+	const hexAddr = "41a614f803b6fd780986a42c78ec9c7f77e6ded13c"
+
+	if got, err := TronBase58ToHex(base58); err != nil || got != hexAddr {
+		t.Errorf("TronBase58ToHex() = %v, %v, want %v", got, err, hexAddr)
+	}
+	for _, addr := range []string{hexAddr, "0x" + hexAddr[2:]} {
+		if got, err := TronHexToBase58(addr); err != nil || got != base58 {
+			t.Errorf("TronHexToBase58(%v) = %v, %v, want %v", addr, got, err, base58)
+		}
+		// Only base58check is a valid Tron address
+		if got, err := NormalizeAddress(Tron, addr); err == nil {
+			t.Errorf("NormalizeAddress(%v) = %v, want an error", addr, got)
+		}
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		network NetworkSymbol
+		addr    string
+		valid   bool
+	}{
+		{Ethereum_Sepolia, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", true},
+		{Binance_Smart_Chain_Mainnet, "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", true},
+		{Ethereum_Mainnet, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", false}, // Checksum mismatch
+		{Ethereum_Mainnet, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA", false},
+		{Ethereum_Mainnet, "5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", false},
+		{Ethereum_Mainnet, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeg", false},
+		{Tron_Shasta, "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", true},
+		{Tron, "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6u", false}, // Checksum mismatch
+		{Tron, "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6", false},
+		{Tron, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", false}, // ETH address
+		{Tron, "41a614f803b6fd780986a42c78ec9c7f77e6ded13c", false}, // Hex
+		{Tron, "1G8jAqwiCJCzRR1G3HES8N2dPK4oRDcNmm", false},         // Version byte 0
+		{Solana, "11111111111111111111111111111111", true},
+		{Solana_Devnet, "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v", true},
+		{Solana, "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTD", false},
+		{Solana, "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt10", false}, // Not base58
+		{"DOGE", "DH5yaieqoZN36fDVciNyRueRGvGLR3mr7L", false},
+	}
+	for _, tt := range tests {
+		err := ValidateAddress(tt.network, tt.addr)
+		if tt.valid && err != nil {
+			t.Errorf("ValidateAddress(%v, %v) = %v, want nil", tt.network, tt.addr, err)
+		}
+		if !tt.valid {
+			var akashicErr *AkashicError
+			if !errors.As(err, &akashicErr) || akashicErr.Code != AkashicErrorCodeInvalidAddress {
+				t.Errorf("ValidateAddress(%v, %v) = %v, want %v", tt.network, tt.addr, err, AkashicErrorCodeInvalidAddress)
+			}
+		}
+	}
+}
+
+func TestBase58RoundTrip(t *testing.T) {
+	for _, data := range [][]byte{{0}, {0, 0, 1}, {1, 2, 3}, {0xff, 0xff}} {
+		decoded, err := base58Decode(base58Encode(data))
+		if err != nil || hex.EncodeToString(decoded) != hex.EncodeToString(data) {
+			t.Errorf("base58Decode(base58Encode(%x)) = %x, %v", data, decoded, err)
+		}
+	}
+}
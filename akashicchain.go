@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"slices"
 	"strings"
 	"time"
@@ -17,8 +18,12 @@ import (
 const acNamespace = "akashicchain"
 const acNativeCoin = "#native"
 
-// contracts
-type contracts struct {
+// How long transactions are valid for unless configured otherwise
+const defaultTxExpiry = 1 * time.Minute
+
+// Contracts are the AkashicChain contracts the SDK builds transactions for.
+// Only needed to register a custom Environment
+type Contracts struct {
 	FxMultiSigner      string // Not a contract
 	Namespace          string // Also not a contract
 	Create             string
@@ -29,7 +34,7 @@ type contracts struct {
 	CreateSecondaryOtk string
 }
 
-var acMainNetContracts = contracts{
+var acMainNetContracts = Contracts{
 	FxMultiSigner:  "ASad1414566948845b404e8b6ac91639cc3643129d0ef8b7828ede7a0ac1044d6e",
 	Create:         "50e1372f0d3805dac4a51299bb0e99960862d7d01f247e85725d99011682b8ac@1",
 	CryptoTransfer: "2bae6ea681826c0307ee047ef68eb0cf53487a257c498de7d081d66de119d666@1",
@@ -38,7 +43,7 @@ var acMainNetContracts = contracts{
 	AssignKey:      "7afea15e8028af9f5aeafb6db6c0d2e8969c0c0492360ab15a6bc3754b818e19@1",
 }
 
-var acTestNetContracts = contracts{
+var acTestNetContracts = Contracts{
 	FxMultiSigner:  "ASeffcb8790aff2439522ef4bd834cca5233dc1670e5fa1c93fa19305323937a17",
 	Create:         "ad171259a7c628ba6993c6bd555f07111525128194aa4226662e48a0b0a93116@1",
 	CryptoTransfer: "a32a8bc21ceaeeaa671573126a246c15ec4dc3a5c825e3cffc9441636019acb1@1",
@@ -162,14 +167,9 @@ func signTransaction(tx acTransaction, otk Otk) (acTransaction, error) {
 	return tx, nil
 }
 
-func keyCreateTransaction(env Environment, coinSymbol NetworkSymbol, otk Otk) (acTransaction, error) {
-	contracts := acTestNetContracts
-	dbIndex := 15
-
-	if env == Production {
-		contracts = acMainNetContracts
-		dbIndex = 0
-	}
+func keyCreateTransaction(envConfig EnvironmentConfig, coinSymbol NetworkSymbol, otk Otk) (acTransaction, error) {
+	contracts := envConfig.Contracts
+	dbIndex := envConfig.DbIndex
 	TxBody := acTransaction{
 		TxObject: txObject{
 			Namespace: acNamespace,
@@ -186,31 +186,31 @@ func keyCreateTransaction(env Environment, coinSymbol NetworkSymbol, otk Otk) (a
 		},
 		Signature: map[string]interface{}{},
 	}
-	addExpireToTx(&TxBody)
+	addExpireToTx(&TxBody, defaultTxExpiry)
 	return signTransaction(TxBody, otk)
 }
 
 // Create an L1 transaction
 func l1Transaction(
-	env Environment,
+	envConfig EnvironmentConfig,
 	identity string,
 	coinSymbol NetworkSymbol,
 	amount string,
 	toAddress string,
 	tokenSymbol TokenSymbol,
 	referenceId string,
+	feeDelegation FeeDelegationStrategy,
+	extraMetadata map[string]interface{},
+	expiry time.Duration,
 ) acTransaction {
-	DbIndex := 15
-	Contracts := acTestNetContracts
+	DbIndex := envConfig.DbIndex
+	Contracts := envConfig.Contracts
 	Token := acNativeCoin
-	Metadata := map[string]interface{}{
-		"referenceId": referenceId,
-	}
-
-	if env == Production {
-		DbIndex = 0
-		Contracts = acMainNetContracts
+	Metadata := maps.Clone(extraMetadata)
+	if Metadata == nil {
+		Metadata = map[string]interface{}{}
 	}
+	Metadata["referenceId"] = referenceId
 
 	if tokenSymbol != "" {
 		Token = string(tokenSymbol)
@@ -223,7 +223,7 @@ func l1Transaction(
 			"token":     Token,
 			"amount":    amount,
 			"to":        toAddress,
-			"delegated": true,
+			"delegated": feeDelegation != FeeDelegationNone,
 		},
 	}
 
@@ -238,13 +238,13 @@ func l1Transaction(
 		},
 		Signature: map[string]interface{}{},
 	}
-	addExpireToTx(&TxBody)
+	addExpireToTx(&TxBody, expiry)
 	return TxBody
 }
 
 // Create and Sign an L2 transaction
 func l2Transaction(
-	env Environment,
+	envConfig EnvironmentConfig,
 	otk Otk,
 	coinSymbol NetworkSymbol,
 	amount string,
@@ -253,18 +253,17 @@ func l2Transaction(
 	initiatedToNonL2 string,
 	referenceId string,
 	isFxBp bool,
+	extraMetadata map[string]interface{},
+	expiry time.Duration,
 ) (acTransaction, error) {
-	DbIndex := 15
-	Contracts := acTestNetContracts
+	DbIndex := envConfig.DbIndex
+	Contracts := envConfig.Contracts
 	Token := acNativeCoin
-	Metadata := map[string]interface{}{
-		"referenceId": referenceId,
-	}
-
-	if env == Production {
-		DbIndex = 0
-		Contracts = acMainNetContracts
+	Metadata := maps.Clone(extraMetadata)
+	if Metadata == nil {
+		Metadata = map[string]interface{}{}
 	}
+	Metadata["referenceId"] = referenceId
 
 	if tokenSymbol != "" {
 		Token = string(tokenSymbol)
@@ -304,15 +303,20 @@ func l2Transaction(
 		},
 		Signature: map[string]interface{}{},
 	}
-	addExpireToTx(&TxBody)
+	addExpireToTx(&TxBody, expiry)
 	return signTransaction(TxBody, otk)
 }
 
-func addExpireToTx(tx *acTransaction) *acTransaction {
+// addExpireToTx makes tx expire after expiry, or after defaultTxExpiry if
+// expiry is not positive. An expiry that is already set is kept
+func addExpireToTx(tx *acTransaction, expiry time.Duration) *acTransaction {
 	if tx.TxObject.Expire != "" {
 		return tx
 	}
-	tx.TxObject.Expire = time.Now().Add(1 * time.Minute).Format(time.RFC3339)
+	if expiry <= 0 {
+		expiry = defaultTxExpiry
+	}
+	tx.TxObject.Expire = time.Now().Add(expiry).Format(time.RFC3339)
 	return tx
 }
 
@@ -335,14 +339,9 @@ func checkForAkashicChainError[T any](response activeLedgerResponse[T, any]) err
 }
 
 // AssignKeyTransaction creates and signs a transaction to assign a key to a user identifier
-func assign(env Environment, otk Otk, ledgerIds []string, identifier string) (acTransaction, error) {
-	contracts := acTestNetContracts
-	dbIndex := 15
-
-	if env == Production {
-		contracts = acMainNetContracts
-		dbIndex = 0
-	}
+func assign(envConfig EnvironmentConfig, otk Otk, ledgerIds []string, identifier string) (acTransaction, error) {
+	contracts := envConfig.Contracts
+	dbIndex := envConfig.DbIndex
 
 	keys := make(map[string]interface{})
 	for i, ledgerId := range ledgerIds {
@@ -370,23 +369,18 @@ func assign(env Environment, otk Otk, ledgerIds []string, identifier string) (ac
 		},
 		Signature: map[string]interface{}{},
 	}
-	addExpireToTx(&TxBody)
+	addExpireToTx(&TxBody, defaultTxExpiry)
 	return signTransaction(TxBody, otk)
 }
 
 func differentialConsensusTransaction(
-	env Environment,
+	envConfig EnvironmentConfig,
 	otk Otk,
 	key iKeyCreationResponse,
 	identifier string,
 ) (acTransaction, error) {
-	contracts := acTestNetContracts
-	dbIndex := 15
-
-	if env == Production {
-		contracts = acMainNetContracts
-		dbIndex = 0
-	}
+	contracts := envConfig.Contracts
+	dbIndex := envConfig.DbIndex
 
 	TxBody := acTransaction{
 		TxObject: txObject{
@@ -415,7 +409,7 @@ func differentialConsensusTransaction(
 		Unanimous: true,
 	}
 
-	addExpireToTx(&TxBody)
+	addExpireToTx(&TxBody, defaultTxExpiry)
 	newOtk := otk
 	newOtk.Identity = "owner"
 	return signTransaction(TxBody, newOtk)
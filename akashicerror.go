@@ -1,6 +1,11 @@
 package akashicpay
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
 
 type AkashicErrorCode string
 
@@ -17,6 +22,13 @@ const (
 	AkashicErrorCodeAssignmentFailed           AkashicErrorCode = "ASSIGNMENT_FAILED"
 	AkashicErrorCodeNetworkEnvironmentMismatch AkashicErrorCode = "NETWORK_ENVIRONMENT_MISMATCH"
 	AkashicErrorCodeDecimalLimitExceeded       AkashicErrorCode = "TOKEN_DECIMAL_LIMIT_EXCEEDED"
+	AkashicErrorCodeRateLimited                AkashicErrorCode = "RATE_LIMITED"
+	AkashicErrorCodeCircuitOpen                AkashicErrorCode = "CIRCUIT_OPEN"
+	AkashicErrorCodeIdempotencyConflict        AkashicErrorCode = "IDEMPOTENCY_CONFLICT"
+	AkashicErrorCodePayoutPending              AkashicErrorCode = "PAYOUT_PENDING"
+	AkashicErrorCodePayoutSkipped              AkashicErrorCode = "PAYOUT_SKIPPED"
+	AkashicErrorCodeInvalidAddress             AkashicErrorCode = "INVALID_ADDRESS"
+	AkashicErrorCodePolicyViolation            AkashicErrorCode = "POLICY_VIOLATION"
 )
 
 var akashicErrorDetail = map[AkashicErrorCode]string{
@@ -32,6 +44,13 @@ var akashicErrorDetail = map[AkashicErrorCode]string{
 	AkashicErrorCodeAssignmentFailed:           "failed to assign wallet. Please try again",
 	AkashicErrorCodeNetworkEnvironmentMismatch: "the L1-network does not match the SDK-environment",
 	AkashicErrorCodeDecimalLimitExceeded:       "the amount exceeds the allowed decimal limit for this currency",
+	AkashicErrorCodeRateLimited:                "request rejected by the client-side rate limit",
+	AkashicErrorCodeCircuitOpen:                "endpoint is failing, request rejected by the circuit breaker",
+	AkashicErrorCodeIdempotencyConflict:        "referenceId was already used for a different payout",
+	AkashicErrorCodePayoutPending:              "a previous payout with this referenceId may still be processed. Try again later",
+	AkashicErrorCodePayoutSkipped:              "payout skipped because an earlier payout of the batch failed",
+	AkashicErrorCodeInvalidAddress:             "invalid address",
+	AkashicErrorCodePolicyViolation:            "payout rejected by a payout policy",
 }
 
 // Custom error that implements the `error` interface
@@ -45,7 +64,7 @@ func (e *AkashicError) Error() string {
 }
 
 func newAkashicError(code AkashicErrorCode, details string) *AkashicError {
-	var Details string
+	Details := details
 	if details == "" {
 		Details = akashicErrorDetail[code]
 	}
@@ -54,3 +73,64 @@ func newAkashicError(code AkashicErrorCode, details string) *AkashicError {
 		Details: Details,
 	}
 }
+
+// Server error codes that correspond to an AkashicErrorCode
+var httpErrorCodes = map[string]AkashicErrorCode{
+	"savingsExceeded": AkashicErrorCodeSavingsExceeded,
+}
+
+// Headers that may carry the id the server assigned to a request
+var requestIdHeaders = []string{"X-Request-Id", "X-Correlation-Id", "X-Amzn-Requestid"}
+
+// HTTPError is returned when AkashicScan, AkashicPay or an AC node responds
+// with an error status. Use errors.As to inspect it. If the server error code
+// is known, errors.As also matches the corresponding *AkashicError
+type HTTPError struct {
+	StatusCode int           // HTTP status code of the response
+	Method     string        // HTTP method of the request
+	Endpoint   string        // URL of the request, without query
+	Body       []byte        // Raw response body
+	ErrorCode  string        // `error` field of a JSON error body
+	Message    string        // `message` field of a JSON error body
+	RequestId  string        // Id the server assigned to the request, if any
+	RetryAfter time.Duration // Parsed Retry-After header, if any
+}
+
+func (e *HTTPError) Error() string {
+	if e.ErrorCode != "" || e.Message != "" {
+		return fmt.Sprintf("%v: %v", e.ErrorCode, e.Message)
+	}
+	return fmt.Sprintf("HTTP Error: %v", e.StatusCode)
+}
+
+// Unwrap returns the *AkashicError the server error code maps to, or nil if
+// the code is unknown
+func (e *HTTPError) Unwrap() error {
+	if code, ok := e.AkashicErrorCode(); ok {
+		return newAkashicError(code, "")
+	}
+	return nil
+}
+
+// AkashicErrorCode returns the AkashicErrorCode the server error code maps
+// to. Servers put the code in either the `message` or the `error` field,
+// possibly followed or preceded by a description
+func (e *HTTPError) AkashicErrorCode() (AkashicErrorCode, bool) {
+	for _, field := range []string{e.Message, e.ErrorCode} {
+		for serverCode, code := range httpErrorCodes {
+			if strings.Contains(field, serverCode) {
+				return code, true
+			}
+		}
+	}
+	return "", false
+}
+
+func requestIdFromHeader(header http.Header) string {
+	for _, h := range requestIdHeaders {
+		if id := header.Get(h); id != "" {
+			return id
+		}
+	}
+	return ""
+}
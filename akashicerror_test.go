@@ -0,0 +1,35 @@
+package akashicpay
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHTTPErrorAkashicErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  HTTPError
+		want AkashicErrorCode
+	}{
+		{name: "bare message", err: HTTPError{Message: "savingsExceeded"}, want: AkashicErrorCodeSavingsExceeded},
+		{name: "bare error", err: HTTPError{ErrorCode: "savingsExceeded"}, want: AkashicErrorCodeSavingsExceeded},
+		{name: "wrapped message", err: HTTPError{ErrorCode: "Bad Request", Message: "savingsExceeded: balance too low"}, want: AkashicErrorCodeSavingsExceeded},
+		{name: "wrapped error", err: HTTPError{ErrorCode: "error: savingsExceeded"}, want: AkashicErrorCodeSavingsExceeded},
+		{name: "unknown", err: HTTPError{ErrorCode: "Bad Request", Message: "invalid amount"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var akashicErr *AkashicError
+			matched := errors.As(&tt.err, &akashicErr)
+			if tt.want == "" {
+				if matched {
+					t.Fatalf("errors.As matched %v, want no match", akashicErr.Code)
+				}
+				return
+			}
+			if !matched || akashicErr.Code != tt.want {
+				t.Fatalf("errors.As = %v, want %v", akashicErr, tt.want)
+			}
+		})
+	}
+}
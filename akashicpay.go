@@ -5,6 +5,7 @@
 package akashicpay
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -12,11 +13,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"log/slog"
+	"maps"
 	"net/url"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,6 +29,9 @@ type CryptoCurrency string
 const (
 	Development Environment = "Development"
 	Production  Environment = "Production"
+	// Local is a private AkashicChain deployment, e.g. in docker-compose. It
+	// must be configured with RegisterEnvironment before use
+	Local Environment = "Local"
 )
 
 // Network supported by AkashicPay, test- and mainnets
@@ -58,14 +62,40 @@ const (
 )
 
 type AkashicPay struct {
+	// Deprecated: TargetNode is the AC node chosen during initialization.
+	// Transactions are sent to CurrentACNode, which changes on failover
 	TargetNode       acNode
 	Env              Environment
+	envConfig        EnvironmentConfig
 	ApiSecret        string
 	isFxBp           bool
 	otk              Otk
 	akashicUrl       string
 	akashicPayUrl    string
 	akashicPayApiUrl string
+	requester        *requester
+	nodes            *nodePool
+	logger           *slog.Logger
+	instrumentation  Instrumentation
+	cache            *responseCache
+	idempotency      *idempotency // nil if disabled
+
+	skipBpCheck         bool
+	nodeMonitorInterval time.Duration
+	submissionPath      SubmissionPath
+	balanceCheck        bool
+	payoutPolicies      []PayoutPolicy
+	txExpiry            time.Duration   // Of payouts, defaultTxExpiry if zero
+	connMu              sync.Mutex      // Guards connecting and connected
+	connecting          *connectAttempt // The Connect in progress, nil if none
+	connected           bool
+}
+
+// connectAttempt is a Connect in progress, which concurrent callers wait for
+type connectAttempt struct {
+	done     chan struct{} // Closed when the attempt ended
+	err      error
+	canceled bool // Whether the attempt ended because its ctx was done
 }
 
 type Balance struct {
@@ -77,46 +107,208 @@ type Balance struct {
 // Construct and initialize a new AkashicPay instance. Returns a pointer to an
 // AkashicPay instance
 func NewAkashicPay(privateKey string, identity string, env Environment, apiSecret string) (*AkashicPay, error) {
+	return NewAkashicPayContext(context.Background(), privateKey, identity, env, apiSecret)
+}
+
+// NewAkashicPayContext is like NewAkashicPay, but uses ctx for the network
+// calls made during initialization
+func NewAkashicPayContext(ctx context.Context, privateKey string, identity string, env Environment, apiSecret string) (*AkashicPay, error) {
+	return NewAkashicPayWithOptionsContext(ctx, privateKey, identity, WithEnvironment(env), WithApiSecret(apiSecret))
+}
+
+// NewAkashicPayWithOptions constructs and initializes a new AkashicPay
+// instance configured by opts. Options allow replacing the HTTP client, the
+// base URLs and the AC node, e.g. to target local stand-ins during testing.
+// Without options it behaves like NewAkashicPay in the Development
+// environment
+func NewAkashicPayWithOptions(privateKey string, identity string, opts ...Option) (*AkashicPay, error) {
+	return NewAkashicPayWithOptionsContext(context.Background(), privateKey, identity, opts...)
+}
+
+// NewAkashicPayWithOptionsContext is like NewAkashicPayWithOptions, but uses
+// ctx for the network calls made during initialization
+func NewAkashicPayWithOptionsContext(ctx context.Context, privateKey string, identity string, opts ...Option) (_ *AkashicPay, err error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx = o.instrumentation.OperationStart(ctx, "NewAkashicPay")
+	defer func() { o.instrumentation.OperationEnd(ctx, "NewAkashicPay", err) }()
+
 	otk, err := reconstructOtkFromPrivateKey(privateKey, identity)
 	if err != nil {
 		return nil, err
 	}
 
-	fastestNode, err := chooseBestACNode(env)
+	envConfig, err := o.environmentConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	urls := getUrls(env)
+	r := newRequester(&o)
 
-	isBp, err := getIsBp(urls.AkashicUrl, identity)
-	if err != nil {
+	var nodes *nodePool
+	if o.acNode != nil {
+		nodes = newStaticNodePool(r, []acNode{*o.acNode}, o.nodeProbeTimeout)
+	} else {
+		nodes = newNodePool(r, envConfig.acNodes(), o.nodeProbeTimeout)
+	}
+
+	urls := envConfig.urls()
+	if o.akashicUrl != "" {
+		urls.AkashicUrl = o.akashicUrl
+	}
+	if o.akashicPayUrl != "" {
+		urls.AkashicPayUrl = o.akashicPayUrl
+	}
+	if o.akashicPayApiUrl != "" {
+		urls.AkashicPayApiUrl = o.akashicPayApiUrl
+	}
+	r.limiters = newRateLimiters(o.rateLimits, urls, nodes.nodes)
+
+	ap := &AkashicPay{
+		Env:                 o.env,
+		envConfig:           envConfig,
+		ApiSecret:           o.apiSecret,
+		otk:                 otk,
+		akashicUrl:          urls.AkashicUrl,
+		akashicPayUrl:       urls.AkashicPayUrl,
+		akashicPayApiUrl:    urls.AkashicPayApiUrl,
+		requester:           r,
+		nodes:               nodes,
+		logger:              o.logger,
+		instrumentation:     o.instrumentation,
+		cache:               newResponseCache(o.cacheStore, o.cacheTTL, urls.AkashicUrl),
+		skipBpCheck:         o.skipBpCheck,
+		nodeMonitorInterval: o.nodeMonitorInterval,
+		submissionPath:      o.submissionPath,
+		balanceCheck:        o.balanceCheck,
+		payoutPolicies:      o.payoutPolicies,
+		txExpiry:            o.txExpiry,
+	}
+	if o.idempotencyStore != nil {
+		ap.idempotency = newIdempotency(o.idempotencyStore)
+	}
+	if o.lazyConnect {
+		return ap, nil
+	}
+	if err := ap.Connect(ctx); err != nil {
 		return nil, err
 	}
-	if !isBp.IsBp {
-		return nil, newAkashicError(AkashicErrorCodeIsNotBp, "")
+	return ap, nil
+}
+
+// Connect selects the AkashicChain node to use and checks that the identity
+// is signed up on AkashicPay. It is called during initialization, unless
+// WithLazyConnect is used. In that case it is called by the first method
+// that needs it, or can be called explicitly, e.g. in a readiness check.
+// After a failure the next call tries again. Safe for concurrent use.
+// Concurrent calls share one attempt, and each stops waiting for it when its
+// own ctx is done
+func (ap *AkashicPay) Connect(ctx context.Context) error {
+	for {
+		ap.connMu.Lock()
+		if ap.connected {
+			ap.connMu.Unlock()
+			return nil
+		}
+		attempt := ap.connecting
+		if attempt == nil {
+			attempt = &connectAttempt{done: make(chan struct{})}
+			ap.connecting = attempt
+			ap.connMu.Unlock()
+			ap.runConnectAttempt(ctx, attempt)
+			return attempt.err
+		}
+		ap.connMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-attempt.done:
+		}
+		// The attempt used the ctx of another caller. If that ended it, try
+		// again with ours
+		if !attempt.canceled {
+			return attempt.err
+		}
 	}
+}
 
-	return &AkashicPay{
-		TargetNode:       fastestNode,
-		Env:              env,
-		ApiSecret:        apiSecret,
-		otk:              otk,
-		isFxBp:           isBp.IsFxBp,
-		akashicUrl:       urls.AkashicUrl,
-		akashicPayUrl:    urls.AkashicPayUrl,
-		akashicPayApiUrl: urls.AkashicPayApiUrl,
-	}, nil
+func (ap *AkashicPay) runConnectAttempt(ctx context.Context, attempt *connectAttempt) {
+	err := ap.connect(ctx)
+
+	ap.connMu.Lock()
+	ap.connecting = nil
+	ap.connected = err == nil
+	ap.connMu.Unlock()
+
+	attempt.err = err
+	attempt.canceled = err != nil && ctx.Err() != nil
+	close(attempt.done)
+}
+
+// connect does the work of Connect. Only one runs at a time
+func (ap *AkashicPay) connect(ctx context.Context) error {
+	if err := ap.nodes.connect(ctx); err != nil {
+		return err
+	}
+
+	if !ap.skipBpCheck {
+		isBp, err := getIsBp(ctx, ap.requester, ap.akashicUrl, ap.otk.Identity)
+		if err != nil {
+			return err
+		}
+		if !isBp.IsBp {
+			return newAkashicError(AkashicErrorCodeIsNotBp, "")
+		}
+		ap.isFxBp = isBp.IsFxBp
+	}
+
+	ap.TargetNode, _ = ap.nodes.current()
+	if ap.nodeMonitorInterval > 0 {
+		ap.nodes.startMonitor(ap.nodeMonitorInterval)
+	}
+	return nil
+}
+
+// CurrentACNode returns the URL of the AkashicChain node transactions are
+// currently sent to
+func (ap *AkashicPay) CurrentACNode() string {
+	node, _ := ap.nodes.current()
+	return node.Node
+}
+
+// NodeStats reports the last known latency, status and error of every
+// AkashicChain node the instance may send transactions to
+func (ap *AkashicPay) NodeStats() []NodeStat {
+	return ap.nodes.snapshot()
+}
+
+// Close stops the background node health monitor, if enabled. The instance
+// must not be used afterwards
+func (ap *AkashicPay) Close() error {
+	ap.nodes.close()
+	return nil
 }
 
 // Get total balances, divided by Network and Token
 func (ap *AkashicPay) GetBalance() ([]Balance, error) {
-	ownerDetails, err := getBalance(ap.akashicUrl, ap.otk.Identity)
+	return ap.GetBalanceContext(context.Background())
+}
+
+// GetBalanceContext is like GetBalance, but uses ctx for the request
+func (ap *AkashicPay) GetBalanceContext(ctx context.Context) (balances []Balance, err error) {
+	ctx, end := ap.startOperation(ctx, "GetBalance")
+	defer func() { end(err) }()
+
+	ownerDetails, err := getBalance(ctx, ap.requester, ap.akashicUrl, ap.otk.Identity)
 
 	if err != nil {
 		return nil, err
 	}
-	balances := make([]Balance, len(ownerDetails.TotalBalances))
+	balances = make([]Balance, len(ownerDetails.TotalBalances))
 	for i, v := range ownerDetails.TotalBalances {
 		balances[i] = Balance{
 			NetworkSymbol: v.CoinSymbol,
@@ -137,140 +329,20 @@ func (ap *AkashicPay) GetBalance() ([]Balance, error) {
 //
 // The return is the L2 hash of the transaction
 func (ap *AkashicPay) Payout(referenceId string, to string, amount string, network NetworkSymbol, token TokenSymbol) (string, error) {
-	if referenceId == "" {
-		return "", errors.New("referenceId may not be zero-valued")
-	}
-	if to == "" {
-		return "", errors.New("to may not be zero-valued")
-	}
-	if amount == "" {
-		return "", errors.New("amount may not be zero-valued")
-	}
-	if network == "" {
-		return "", errors.New("network may not be zero-valued")
-	}
-
-	if err := validateDecimalPlaces(amount, network, token); err != nil {
-		return "", err
-	}
-
-	ToAddress := to
-	InitiatedToNonL2 := ""
-	IsL2 := false
-
-	DecimalAmount, err := convertToSmallestUnit(amount, network, token)
-	if err != nil {
-		return "", err
-	}
-
-	L2Lookup, err := ap.LookForL2Address(to, network)
-
-	if err != nil {
-		return "", err
-	}
-
-	InputIsL1, err := regexp.MatchString(networkDictionary[network].AddressRegex, to)
-	if err != nil {
-		return "", err
-	}
-	InputIsL2, err := regexp.MatchString(l2RegexWithOptionalPrefix, to)
-	if err != nil {
-		return "", err
-	}
-
-	if InputIsL1 {
-		if L2Lookup.L2Address != "" {
-			ToAddress = L2Lookup.L2Address
-			InitiatedToNonL2 = to
-			IsL2 = true
-		}
-	} else if InputIsL2 {
-		if L2Lookup.L2Address == "" {
-			return "", newAkashicError(AkashicErrorCodeL2AddressNotFound, "")
-		}
-		IsL2 = true
-	} else {
-		// Must be alias
-		if L2Lookup.L2Address == "" {
-			return "", newAkashicError(AkashicErrorCodeL2AddressNotFound, "")
-		}
-		ToAddress = L2Lookup.L2Address
-		InitiatedToNonL2 = to
-		IsL2 = true
-	}
-
-	// L2
-	if IsL2 {
-		acToken := mapUSDTToTether(network, token)
-		signedL2Tx, err := l2Transaction(ap.Env, ap.otk, network, DecimalAmount, ToAddress, acToken, InitiatedToNonL2, referenceId, ap.isFxBp)
-		if err != nil {
-			return "", err
-		}
-
-		//If FX, double-sign on BE
-		if ap.isFxBp {
-			res, err := prepareL2Txn(ap.akashicUrl, prepareL2TxnDto{SignedTx: signedL2Tx})
-			if err != nil {
-				return "", err
-			}
-			signedL2Tx = res.PreparedTxn
-		}
-
-		acRes, err := post[activeLedgerResponse[any, any]](ap.TargetNode.Node, signedL2Tx)
-		if err != nil {
-			return "", err
-		}
-		acErr := checkForAkashicChainError(acRes)
-		if acErr != nil {
-			return "", acErr
-		}
-
-		return prefixWithAS(acRes.Umid)
-	}
-
-	// L1
-
-	Payload := prepareTxnDto{
-		ToAddress:             to,
-		Amount:                amount,
-		NetworkSymbol:         network,
-		TokenSymbol:           token,
-		ReferenceId:           referenceId,
-		Identity:              ap.otk.Identity,
-		FeeDelegationStrategy: ffeeDelegationDelegate,
-	}
-
-	res, err := prepareL1Txn(ap.akashicUrl, Payload)
-
-	PreparedTxn := res.PreparedTxn
-
-	if err != nil {
-		if strings.Contains(err.Error(), "savingsExceeded") {
-			return "", newAkashicError(AkashicErrorCodeSavingsExceeded, "")
-		} else if strings.Contains(err.Error(), "connection refused") {
-			PreparedTxn = l1Transaction(ap.Env, ap.otk.Identity, network, amount, to, token, referenceId)
-		} else {
-			return "", err
-		}
-	}
-
-	SignedTxn, err := signTransaction(PreparedTxn, ap.otk)
-
-	if err != nil {
-		return "", err
-	}
-
-	acRes, err := post[activeLedgerResponse[any, any]](ap.TargetNode.Node, SignedTxn)
-
-	if err != nil {
-		return "", err
-	}
-	acErr := checkForAkashicChainError(acRes)
-	if acErr != nil {
-		return "", acErr
-	}
+	return ap.PayoutContext(context.Background(), referenceId, to, amount, network, token)
+}
 
-	return prefixWithAS(acRes.Umid)
+// PayoutContext is like Payout, but uses ctx for every request made while
+// preparing and submitting the transaction
+func (ap *AkashicPay) PayoutContext(ctx context.Context, referenceId string, to string, amount string, network NetworkSymbol, token TokenSymbol) (string, error) {
+	result, err := ap.PayoutWithOptions(ctx, PayoutRequest{
+		ReferenceId: referenceId,
+		To:          to,
+		Amount:      amount,
+		Network:     network,
+		Token:       token,
+	})
+	return result.L2Hash, err
 }
 
 // GetDepositUrl returns a url where a user can make deposits
@@ -285,7 +357,15 @@ func (ap *AkashicPay) Payout(referenceId string, to string, amount string, netwo
 // redirectUrl is a parameter which sets a URL to redirect to from the
 // deposit URL, can be left out ("")
 func (ap *AkashicPay) GetDepositUrl(identifier string, referenceId string, receiveCurrencies []CryptoCurrency, networks []NetworkSymbol, redirectUrl string) (string, error) {
-	return ap.getDepositUrlFunc(identifier, referenceId, receiveCurrencies, networks, redirectUrl, "", "", 0)
+	return ap.GetDepositUrlContext(context.Background(), identifier, referenceId, receiveCurrencies, networks, redirectUrl)
+}
+
+// GetDepositUrlContext is like GetDepositUrl, but uses ctx for the requests
+func (ap *AkashicPay) GetDepositUrlContext(ctx context.Context, identifier string, referenceId string, receiveCurrencies []CryptoCurrency, networks []NetworkSymbol, redirectUrl string) (depositUrl string, err error) {
+	ctx, end := ap.startOperation(ctx, "GetDepositUrl")
+	defer func() { end(err) }()
+
+	return ap.getDepositUrlFunc(ctx, identifier, referenceId, receiveCurrencies, networks, redirectUrl, "", "", 0)
 }
 
 // Same as GetDepositUrl, but requires specifying the value of the deposit via
@@ -295,6 +375,15 @@ func (ap *AkashicPay) GetDepositUrl(identifier string, referenceId string, recei
 //
 // Set the markupPercantage to adjust the exchange-rate for a markup/discount
 func (ap *AkashicPay) GetDepositUrlWithRequestedValue(identifier string, referenceId string, receiveCurrencies []CryptoCurrency, networks []NetworkSymbol, redirectUrl string, requestedCurrency Currency, requestedAmount string, markupPercentage float64) (string, error) {
+	return ap.GetDepositUrlWithRequestedValueContext(context.Background(), identifier, referenceId, receiveCurrencies, networks, redirectUrl, requestedCurrency, requestedAmount, markupPercentage)
+}
+
+// GetDepositUrlWithRequestedValueContext is like
+// GetDepositUrlWithRequestedValue, but uses ctx for the requests
+func (ap *AkashicPay) GetDepositUrlWithRequestedValueContext(ctx context.Context, identifier string, referenceId string, receiveCurrencies []CryptoCurrency, networks []NetworkSymbol, redirectUrl string, requestedCurrency Currency, requestedAmount string, markupPercentage float64) (depositUrl string, err error) {
+	ctx, end := ap.startOperation(ctx, "GetDepositUrlWithRequestedValue")
+	defer func() { end(err) }()
+
 	if referenceId == "" {
 		return "", errors.New("referenceId may not be zero-valued")
 	}
@@ -304,7 +393,7 @@ func (ap *AkashicPay) GetDepositUrlWithRequestedValue(identifier string, referen
 	if requestedAmount == "" {
 		return "", errors.New("requestedAmount may not be zero-valued")
 	}
-	return ap.getDepositUrlFunc(identifier, referenceId, receiveCurrencies, networks, redirectUrl, requestedCurrency, requestedAmount, markupPercentage)
+	return ap.getDepositUrlFunc(ctx, identifier, referenceId, receiveCurrencies, networks, redirectUrl, requestedCurrency, requestedAmount, markupPercentage)
 }
 
 // GetDepositAddress returns an L1-address on the specified network for a user
@@ -312,7 +401,16 @@ func (ap *AkashicPay) GetDepositUrlWithRequestedValue(identifier string, referen
 //
 // referenceId is a parameter used to identify the order, can be left out ("")
 func (ap *AkashicPay) GetDepositAddress(network NetworkSymbol, identifier string, referenceId string) (IDepositAddress, error) {
-	return ap.getDepositAddressFunc(network, identifier, referenceId, "", "", "", 0)
+	return ap.GetDepositAddressContext(context.Background(), network, identifier, referenceId)
+}
+
+// GetDepositAddressContext is like GetDepositAddress, but uses ctx for the
+// requests, including any key creation on AkashicChain
+func (ap *AkashicPay) GetDepositAddressContext(ctx context.Context, network NetworkSymbol, identifier string, referenceId string) (depositAddress IDepositAddress, err error) {
+	ctx, end := ap.startOperation(ctx, "GetDepositAddress")
+	defer func() { end(err) }()
+
+	return ap.getDepositAddressFunc(ctx, network, identifier, referenceId, "", "", "", 0)
 }
 
 // Same as GetDepositAddress, but requires specifying the value of the deposit via
@@ -322,6 +420,15 @@ func (ap *AkashicPay) GetDepositAddress(network NetworkSymbol, identifier string
 //
 // Set the markupPercantage to adjust the exchange-rate for a markup/discount
 func (ap *AkashicPay) GetDepositAddressWithRequestedValue(network NetworkSymbol, identifier string, referenceId string, requestedCurrency Currency, requestedAmount string, token TokenSymbol, markupPercentage float64) (IDepositAddress, error) {
+	return ap.GetDepositAddressWithRequestedValueContext(context.Background(), network, identifier, referenceId, requestedCurrency, requestedAmount, token, markupPercentage)
+}
+
+// GetDepositAddressWithRequestedValueContext is like
+// GetDepositAddressWithRequestedValue, but uses ctx for the requests
+func (ap *AkashicPay) GetDepositAddressWithRequestedValueContext(ctx context.Context, network NetworkSymbol, identifier string, referenceId string, requestedCurrency Currency, requestedAmount string, token TokenSymbol, markupPercentage float64) (depositAddress IDepositAddress, err error) {
+	ctx, end := ap.startOperation(ctx, "GetDepositAddressWithRequestedValue")
+	defer func() { end(err) }()
+
 	if referenceId == "" {
 		return IDepositAddress{}, errors.New("referenceId may not be zero-valued")
 	}
@@ -331,52 +438,116 @@ func (ap *AkashicPay) GetDepositAddressWithRequestedValue(network NetworkSymbol,
 	if requestedAmount == "" {
 		return IDepositAddress{}, errors.New("requestedAmount may not be zero-valued")
 	}
-	return ap.getDepositAddressFunc(network, identifier, referenceId, token, requestedCurrency, requestedAmount, markupPercentage)
+	return ap.getDepositAddressFunc(ctx, network, identifier, referenceId, token, requestedCurrency, requestedAmount, markupPercentage)
 }
 
 // GetExchangeRates return the exchange rates for all supported main-net coins
 // in the value of the requested currency
 func (ap *AkashicPay) GetExchangeRates(requestedCurrency Currency) (IGetExchangeRatesResult, error) {
+	return ap.GetExchangeRatesContext(context.Background(), requestedCurrency)
+}
+
+// GetExchangeRatesContext is like GetExchangeRates, but uses ctx for the
+// request
+func (ap *AkashicPay) GetExchangeRatesContext(ctx context.Context, requestedCurrency Currency) (rates IGetExchangeRatesResult, err error) {
+	ctx, end := ap.startOperation(ctx, "GetExchangeRates")
+	defer func() { end(err) }()
+
 	if requestedCurrency == "" {
 		return IGetExchangeRatesResult{}, errors.New("requestedCurrency may not be zero-valued")
 	}
-	return getExchangeRates(ap.akashicUrl, requestedCurrency)
+	rates, err = cached(ctx, ap.cache, CacheExchangeRates, string(requestedCurrency), func(ctx context.Context) (IGetExchangeRatesResult, error) {
+		return getExchangeRates(ctx, ap.requester, ap.akashicUrl, requestedCurrency)
+	})
+	return maps.Clone(rates), err
 }
 
 // LookForL2Address checks which L2-address an alias or L1-address belongs to.
 // Or call with an L2-address to verify it exists
 func (ap *AkashicPay) LookForL2Address(aliasOrL1OrL2Address string, network NetworkSymbol) (ILookForL2AddressResponse, error) {
+	return ap.LookForL2AddressContext(context.Background(), aliasOrL1OrL2Address, network)
+}
+
+// LookForL2AddressContext is like LookForL2Address, but uses ctx for the
+// request
+func (ap *AkashicPay) LookForL2AddressContext(ctx context.Context, aliasOrL1OrL2Address string, network NetworkSymbol) (lookup ILookForL2AddressResponse, err error) {
+	ctx, end := ap.startOperation(ctx, "LookForL2Address")
+	defer func() { end(err) }()
+
 	if aliasOrL1OrL2Address == "" {
 		return ILookForL2AddressResponse{}, errors.New("aliasOrL1OrL2Address may not be zero-valued")
 	}
-	return getL2Lookup(ap.akashicUrl, aliasOrL1OrL2Address, network)
+	lookup, err = cached(ctx, ap.cache, CacheL2Lookup, string(network)+":"+aliasOrL1OrL2Address, func(ctx context.Context) (ILookForL2AddressResponse, error) {
+		return getL2Lookup(ctx, ap.requester, ap.akashicUrl, aliasOrL1OrL2Address, network)
+	})
+	// If AkashicScan is unreachable the receiver is treated as having no L2
+	// address. Not cached, so the next lookup asks again
+	if isConnectionRefused(err) {
+		return ILookForL2AddressResponse{}, nil
+	}
+	return lookup, err
 }
 
 // Get all or a subset of transactions.
 //
 // Specify Page and Limit for pagination
 func (ap *AkashicPay) GetTransfers(getTransactionParams IGetTransactions) ([]ITransaction, error) {
+	return ap.GetTransfersContext(context.Background(), getTransactionParams)
+}
+
+// GetTransfersContext is like GetTransfers, but uses ctx for the request
+func (ap *AkashicPay) GetTransfersContext(ctx context.Context, getTransactionParams IGetTransactions) (transactions []ITransaction, err error) {
+	ctx, end := ap.startOperation(ctx, "GetTransfers")
+	defer func() { end(err) }()
+
 	validLimits := map[int]bool{0: true, 10: true, 25: true, 50: true, 100: true}
 	if !validLimits[getTransactionParams.Limit] {
 		return nil, errors.New("limit must be one of 10, 25, 50, or 100")
 	}
-	return getTransfers(ap.akashicUrl, ap.otk.Identity, getTransactionParams)
+	return getTransfers(ctx, ap.requester, ap.akashicUrl, ap.otk.Identity, getTransactionParams)
 }
 
 // GetTransactionDetails returns details about an individual transactions
 //
 // Returns an empty interface if no transaction found
 func (ap *AkashicPay) GetTransactionDetails(l2Hash string) (ITransaction, error) {
+	return ap.GetTransactionDetailsContext(context.Background(), l2Hash)
+}
+
+// GetTransactionDetailsContext is like GetTransactionDetails, but uses ctx
+// for the request
+func (ap *AkashicPay) GetTransactionDetailsContext(ctx context.Context, l2Hash string) (transaction ITransaction, err error) {
+	ctx, end := ap.startOperation(ctx, "GetTransactionDetails")
+	defer func() { end(err) }()
+
 	if l2Hash == "" {
 		return ITransaction{}, errors.New("l2Hash may not be zero-valued")
 	}
-	return getTransactionDetails(ap.akashicUrl, l2Hash)
+	return getTransactionDetails(ctx, ap.requester, ap.akashicUrl, l2Hash)
 }
 
 // Get the currently supported currencies in AkashicPay
 // Returns a map from currencies to a list of networks supported for that currency
 func (ap *AkashicPay) GetSupportedCurrencies() (map[CryptoCurrency][]NetworkSymbol, error) {
-	return getSupportedCurrencies(ap.akashicUrl)
+	return ap.GetSupportedCurrenciesContext(context.Background())
+}
+
+// GetSupportedCurrenciesContext is like GetSupportedCurrencies, but uses ctx
+// for the request
+func (ap *AkashicPay) GetSupportedCurrenciesContext(ctx context.Context) (currencies map[CryptoCurrency][]NetworkSymbol, err error) {
+	ctx, end := ap.startOperation(ctx, "GetSupportedCurrencies")
+	defer func() { end(err) }()
+
+	currencies, err = ap.supportedCurrencies(ctx)
+	return cloneSupportedCurrencies(currencies), err
+}
+
+// supportedCurrencies returns the possibly cached supported currencies. The
+// result must not be modified
+func (ap *AkashicPay) supportedCurrencies(ctx context.Context) (map[CryptoCurrency][]NetworkSymbol, error) {
+	return cached(ctx, ap.cache, CacheSupportedCurrencies, "", func(ctx context.Context) (map[CryptoCurrency][]NetworkSymbol, error) {
+		return getSupportedCurrencies(ctx, ap.requester, ap.akashicUrl)
+	})
 }
 
 // VerifySignature can be used to verify a callback has not been altered. You
@@ -417,73 +588,15 @@ func (ap *AkashicPay) VerifySignature(callback string, signature string) (bool,
 	return hexEncodedMAC == signature, nil
 }
 
-func chooseBestACNode(env Environment) (acNode, error) {
-	var nodes map[string]acNode
-	if env == Production {
-		nodes = acNodes
-	} else {
-		nodes = acDevNodes
-	}
-
-	type Result struct {
-		Node   acNode
-		Status int
-		Error  error
-	}
-
-	type AcResponse struct {
-		Status int `json:"status"`
-	}
-
-	// Use a goroutine to concurrently check node health
-	results := make(chan Result, len(nodes))
-	for _, node := range nodes {
-		go func(node acNode) {
-			resp, err := http.Get(node.Node + "a/status")
-			if err != nil {
-				results <- Result{acNode{}, 0, err}
-				return
-			}
-			defer resp.Body.Close()
-
-			body, err := io.ReadAll(resp.Body)
-
-			if err != nil {
-				results <- Result{acNode{}, 0, err}
-				return
-			}
-
-			var nodeData AcResponse
-			err = json.Unmarshal(body, &nodeData)
-			if err != nil {
-				results <- Result{acNode{}, 0, err}
-				return
-			}
-
-			results <- Result{node, nodeData.Status, nil}
-		}(node)
-	}
-
-	for i := range results {
-		// When first 4 comes, return that node
-		if i.Status == 4 {
-			return i.Node, nil
-		}
-	}
-
-	// if we don't get any 4s we return an error
-	return acNode{}, errors.New("no healthy AC node")
-}
-
-func (ap *AkashicPay) getDepositUrlFunc(identifier string, referenceId string, receiveCurrencies []CryptoCurrency, networks []NetworkSymbol, redirectUrl string, requestedCurrency Currency, requestedAmount string, markupPercentage float64) (string, error) {
+func (ap *AkashicPay) getDepositUrlFunc(ctx context.Context, identifier string, referenceId string, receiveCurrencies []CryptoCurrency, networks []NetworkSymbol, redirectUrl string, requestedCurrency Currency, requestedAmount string, markupPercentage float64) (string, error) {
 	if identifier == "" {
 		return "", errors.New("identifier may not be zero-valued")
 	}
-	keys, err := getKeysByOwnerAndIdentifier(ap.akashicPayApiUrl, ap.otk.Identity, identifier)
+	keys, err := getKeysByOwnerAndIdentifier(ctx, ap.requester, ap.akashicPayApiUrl, ap.otk.Identity, identifier)
 	if err != nil {
 		return "", err
 	}
-	preseedNetworks, err := ap.getPreseedNetworks()
+	preseedNetworks, err := ap.getPreseedNetworks(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -505,7 +618,7 @@ func (ap *AkashicPay) getDepositUrlFunc(identifier string, referenceId string, r
 
 	// bulk create or assign keys for unassigned networks
 	if len(unassignedNetworks) > 0 {
-		err := ap.bulkCreateOrAssignKeys(unassignedNetworks, identifier)
+		err := ap.bulkCreateOrAssignKeys(ctx, unassignedNetworks, identifier)
 		if err != nil {
 			return "", err
 		}
@@ -533,7 +646,7 @@ func (ap *AkashicPay) getDepositUrlFunc(identifier string, referenceId string, r
 		}
 		payload.Signature = signature
 		// create a deposit order
-		_, err = createDepositOrder(ap.akashicPayApiUrl, payload)
+		_, err = createDepositOrder(ctx, ap.requester, ap.akashicPayApiUrl, payload)
 		if err != nil {
 			return "", err
 		}
@@ -558,14 +671,14 @@ func (ap *AkashicPay) getDepositUrlFunc(identifier string, referenceId string, r
 
 // createKey creates a new key on the specified network for the given identifier
 // Returns the newly created key response
-func (ap *AkashicPay) createKey(network NetworkSymbol, identifier string) (iKeyCreationResponse, error) {
+func (ap *AkashicPay) createKey(ctx context.Context, network NetworkSymbol, identifier string) (iKeyCreationResponse, error) {
 	// Create a new key
-	tx, err := keyCreateTransaction(ap.Env, network, ap.otk)
+	tx, err := keyCreateTransaction(ap.envConfig, network, ap.otk)
 	if err != nil {
 		return iKeyCreationResponse{}, err
 	}
 
-	createKeyRes, err := post[activeLedgerResponse[iKeyCreationResponse, any]](ap.TargetNode.Node, tx)
+	createKeyRes, _, err := submitTransaction[iKeyCreationResponse](ctx, ap, tx)
 	if err != nil {
 		return iKeyCreationResponse{}, err
 	}
@@ -576,12 +689,12 @@ func (ap *AkashicPay) createKey(network NetworkSymbol, identifier string) (iKeyC
 	newKey := createKeyRes.Responses[0]
 
 	// Execute differential consensus transaction
-	diffConTx, err := differentialConsensusTransaction(ap.Env, ap.otk, newKey, identifier)
+	diffConTx, err := differentialConsensusTransaction(ap.envConfig, ap.otk, newKey, identifier)
 	if err != nil {
 		return iKeyCreationResponse{}, err
 	}
 
-	diffConTxResp, err := post[activeLedgerResponse[any, any]](ap.TargetNode.Node, diffConTx)
+	diffConTxResp, _, err := submitTransaction[any](ctx, ap, diffConTx)
 	if err != nil {
 		return iKeyCreationResponse{}, err
 	}
@@ -595,10 +708,10 @@ func (ap *AkashicPay) createKey(network NetworkSymbol, identifier string) (iKeyC
 
 // bulkCreateOrAssignKeys creates or assigns keys for multiple networks for a given identifier
 // This function processes multiple networks and either creates new keys or assigns existing unassigned keys
-func (ap *AkashicPay) bulkCreateOrAssignKeys(networks []NetworkSymbol, identifier string) error {
+func (ap *AkashicPay) bulkCreateOrAssignKeys(ctx context.Context, networks []NetworkSymbol, identifier string) error {
 	var unassignedLedgerIds []string
 
-	keys, err := getByOwnerAndIdentifierKeys(ap.akashicUrl, networks, identifier, ap.otk.Identity)
+	keys, err := getByOwnerAndIdentifierKeys(ctx, ap.requester, ap.akashicUrl, networks, identifier, ap.otk.Identity)
 	if err != nil {
 		return err
 	}
@@ -609,7 +722,7 @@ func (ap *AkashicPay) bulkCreateOrAssignKeys(networks []NetworkSymbol, identifie
 			unassignedLedgerIds = append(unassignedLedgerIds, key.UnassignedLedgerId)
 		} else if key.Address == "" && key.UnassignedLedgerId == "" {
 			// If both do not exist, create new key and continue
-			_, err := ap.createKey(key.Network, identifier)
+			_, err := ap.createKey(ctx, key.Network, identifier)
 			if err != nil {
 				return err
 			}
@@ -618,13 +731,13 @@ func (ap *AkashicPay) bulkCreateOrAssignKeys(networks []NetworkSymbol, identifie
 
 	// If there are unassigned ledger IDs, assign them in bulk
 	if len(unassignedLedgerIds) > 0 {
-		tx, err := assign(ap.Env, ap.otk, unassignedLedgerIds, identifier)
+		tx, err := assign(ap.envConfig, ap.otk, unassignedLedgerIds, identifier)
 		if err != nil {
 			return err
 		}
 
 		// Assign keys to the user
-		acRes, err := post[activeLedgerResponse[[]iKeyCreationResponse, any]](ap.TargetNode.Node, tx)
+		acRes, _, err := submitTransaction[[]iKeyCreationResponse](ctx, ap, tx)
 		if err != nil {
 			return err
 		}
@@ -643,10 +756,10 @@ func (ap *AkashicPay) bulkCreateOrAssignKeys(networks []NetworkSymbol, identifie
 	return nil
 }
 
-func (ap *AkashicPay) getDepositAddressFunc(network NetworkSymbol, identifier string, referenceId string, token TokenSymbol, requestedCurrency Currency, requestedAmount string, markupPercentage float64) (IDepositAddress, error) {
+func (ap *AkashicPay) getDepositAddressFunc(ctx context.Context, network NetworkSymbol, identifier string, referenceId string, token TokenSymbol, requestedCurrency Currency, requestedAmount string, markupPercentage float64) (IDepositAddress, error) {
 	// Check environment and network compatibility
-	if (ap.Env == Development && (network == Ethereum_Mainnet || network == Tron)) ||
-		(ap.Env == Production && (network == Ethereum_Sepolia || network == Tron_Shasta)) {
+	if (!ap.envConfig.Mainnet && (network == Ethereum_Mainnet || network == Tron)) ||
+		(ap.envConfig.Mainnet && (network == Ethereum_Sepolia || network == Tron_Shasta)) {
 		return IDepositAddress{}, newAkashicError(AkashicErrorCodeNetworkEnvironmentMismatch, "")
 	}
 	if identifier == "" {
@@ -655,19 +768,19 @@ func (ap *AkashicPay) getDepositAddressFunc(network NetworkSymbol, identifier st
 	if network == "" {
 		return IDepositAddress{}, errors.New("network may not be zero-valued")
 	}
-	response, err := getByOwnerAndIdentifier(ap.akashicUrl, network, identifier, ap.otk.Identity)
+	response, err := getByOwnerAndIdentifier(ctx, ap.requester, ap.akashicUrl, network, identifier, ap.otk.Identity)
 	if err != nil {
 		return IDepositAddress{}, err
 	}
 
 	if response.Address != "" {
 		if response.UnassignedLedgerId != "" {
-			tx, err := assign(ap.Env, ap.otk, []string{response.UnassignedLedgerId}, identifier)
+			tx, err := assign(ap.envConfig, ap.otk, []string{response.UnassignedLedgerId}, identifier)
 			if err != nil {
 				return IDepositAddress{}, err
 			}
 
-			acRes, err := post[activeLedgerResponse[iKeyCreationResponse, any]](ap.TargetNode.Node, tx)
+			acRes, _, err := submitTransaction[iKeyCreationResponse](ctx, ap, tx)
 			if err != nil {
 				return IDepositAddress{}, err
 			}
@@ -679,7 +792,7 @@ func (ap *AkashicPay) getDepositAddressFunc(network NetworkSymbol, identifier st
 		}
 
 		if referenceId != "" {
-			depositOrder, err := ap.createDepositPayloadAndOrder(referenceId, identifier, response.Address, network, token, requestedCurrency, requestedAmount, markupPercentage)
+			depositOrder, err := ap.createDepositPayloadAndOrder(ctx, referenceId, identifier, response.Address, network, token, requestedCurrency, requestedAmount, markupPercentage)
 			if err != nil {
 				return IDepositAddress{}, err
 			}
@@ -704,14 +817,14 @@ func (ap *AkashicPay) getDepositAddressFunc(network NetworkSymbol, identifier st
 	}
 
 	// If no address found, create a new key
-	newKey, err := ap.createKey(network, identifier)
+	newKey, err := ap.createKey(ctx, network, identifier)
 	if err != nil {
 		return IDepositAddress{}, err
 	}
 
 	// If referenceId is provided, create a deposit order with new key address
 	if referenceId != "" {
-		depositOrder, err := ap.createDepositPayloadAndOrder(referenceId, identifier, newKey.Address, network, token, requestedCurrency, requestedAmount, markupPercentage)
+		depositOrder, err := ap.createDepositPayloadAndOrder(ctx, referenceId, identifier, newKey.Address, network, token, requestedCurrency, requestedAmount, markupPercentage)
 		if err != nil {
 			return IDepositAddress{}, err
 		}
@@ -735,7 +848,7 @@ func (ap *AkashicPay) getDepositAddressFunc(network NetworkSymbol, identifier st
 	}, nil
 }
 
-func (ap *AkashicPay) createDepositPayloadAndOrder(referenceId string, identifier string, address string, network NetworkSymbol, tokenSymbol TokenSymbol, requestedCurrency Currency, requestedAmount string, markupPercentage float64) (iCreateDepositOrderResponse, error) {
+func (ap *AkashicPay) createDepositPayloadAndOrder(ctx context.Context, referenceId string, identifier string, address string, network NetworkSymbol, tokenSymbol TokenSymbol, requestedCurrency Currency, requestedAmount string, markupPercentage float64) (iCreateDepositOrderResponse, error) {
 	payload := iCreateDepositOrder{
 		Identity:    ap.otk.Identity,
 		Expires:     time.Now().UnixMilli() + 60*1000,
@@ -763,12 +876,12 @@ func (ap *AkashicPay) createDepositPayloadAndOrder(referenceId string, identifie
 	}
 	createOrderPayload := payload
 	createOrderPayload.Signature = signature
-	return createDepositOrder(ap.akashicPayApiUrl, createOrderPayload)
+	return createDepositOrder(ctx, ap.requester, ap.akashicPayApiUrl, createOrderPayload)
 }
 
 // getPreseedNetworks returns a list of networks that need to create key or assign preseed keys
-func (ap *AkashicPay) getPreseedNetworks() ([]NetworkSymbol, error) {
-	supportedCurrencies, err := getSupportedCurrencies(ap.akashicUrl)
+func (ap *AkashicPay) getPreseedNetworks(ctx context.Context) ([]NetworkSymbol, error) {
+	supportedCurrencies, err := ap.supportedCurrencies(ctx)
 	if err != nil {
 		return nil, err
 	}
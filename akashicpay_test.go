@@ -0,0 +1,122 @@
+package akashicpay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testPrivateKey = "0x" + strings.Repeat("1", 64)
+	testIdentity   = "AS" + strings.Repeat("0", 64)
+)
+
+// newTestServer starts a server for the duration of the test and returns its
+// URL
+func newTestServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// newTestPay returns a lazily connecting instance that sends its requests,
+// to AkashicScan and AkashicChain alike, to serverUrl
+func newTestPay(t *testing.T, serverUrl string, opts ...Option) *AkashicPay {
+	t.Helper()
+	opts = append([]Option{
+		WithAkashicScanUrl(serverUrl),
+		WithACNode(serverUrl, ""),
+		WithLazyConnect(),
+	}, opts...)
+	ap, err := NewAkashicPayWithOptions(testPrivateKey, testIdentity, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ap
+}
+
+// newConnectTestPay returns an instance whose BP check blocks until release
+// is closed
+func newConnectTestPay(t *testing.T, release <-chan struct{}) *AkashicPay {
+	t.Helper()
+	return newTestPay(t, newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+			w.Write([]byte(`{"isBp": true}`))
+		case <-r.Context().Done():
+		}
+	})))
+}
+
+func TestConnectWaitersUseOwnDeadline(t *testing.T) {
+	release := make(chan struct{})
+	ap := newConnectTestPay(t, release)
+
+	first := make(chan error)
+	go func() { first <- ap.Connect(context.Background()) }()
+	// Let the first call start the attempt
+	for {
+		ap.connMu.Lock()
+		started := ap.connecting != nil
+		ap.connMu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := ap.Connect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Connect() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("Connect() waited %v past its deadline", waited)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("first Connect() = %v", err)
+	}
+	if err := ap.Connect(ctx); err != nil {
+		t.Errorf("Connect() after connecting = %v, want nil", err)
+	}
+}
+
+func TestConnectRetriesAfterCanceledAttempt(t *testing.T) {
+	release := make(chan struct{})
+	ap := newConnectTestPay(t, release)
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() { first <- ap.Connect(firstCtx) }()
+	for {
+		ap.connMu.Lock()
+		started := ap.connecting != nil
+		ap.connMu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	second := make(chan error)
+	go func() { second <- ap.Connect(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// The waiting call must not fail because the first caller gave up
+	cancelFirst()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first Connect() = %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Fatalf("second Connect() = %v, want nil", err)
+	}
+}
@@ -1,6 +1,7 @@
 package akashicpay
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"strconv"
@@ -10,13 +11,6 @@ import (
 
 // Akashic Requests
 
-type feeDelegationStrategy string
-
-const (
-	feeDelegationNone      feeDelegationStrategy = "None"
-	ffeeDelegationDelegate feeDelegationStrategy = "Delegate"
-)
-
 type prepareTxnDto struct {
 	ToAddress             string                `json:"toAddress"`
 	NetworkSymbol         NetworkSymbol         `json:"coinSymbol"`
@@ -24,7 +18,7 @@ type prepareTxnDto struct {
 	TokenSymbol           TokenSymbol           `json:"tokenSymbol,omitempty"`
 	Identity              string                `json:"identity"`
 	ReferenceId           string                `json:"referenceId"`
-	FeeDelegationStrategy feeDelegationStrategy `json:"feeDelegationStrategy"`
+	FeeDelegationStrategy FeeDelegationStrategy `json:"feeDelegationStrategy"`
 }
 type prepareL2TxnDto struct {
 	SignedTx acTransaction `json:"signedTx"`
@@ -85,7 +79,7 @@ const (
 	ownerBalanceEndpoint        = "/v0/owner/details"
 	transactionsDetailsEndpoint = "/v0/transactions/transfer"
 	identifierLookupEndpoint    = "/v0/key/bp-deposit-key"
-	identifierLookupsEndpoint    = "/v0/key/bp-deposit-keys"
+	identifierLookupsEndpoint   = "/v0/key/bp-deposit-keys"
 	allKeysOfIdentifierEndpoint = "/v0/key/all-bp-deposit-keys"
 	supportedCurrenciesEndpoint = "/v1/config/supported-currencies"
 	createDepositOrderEndpoint  = "/v0/deposit-request"
@@ -94,31 +88,31 @@ const (
 	exchangeRatesEndpoint       = "/v0/exchange-rate"
 )
 
-func getIsBp(baseUrl string, l2Address string) (isBpResponse, error) {
+func getIsBp(ctx context.Context, r *requester, baseUrl string, l2Address string) (isBpResponse, error) {
 	url := fmt.Sprintf("%v%v?address=%v",
 		baseUrl,
 		isBpEndpoint,
 		l2Address,
 	)
-	isBp, err := get[isBpResponse](url)
+	isBp, err := get[isBpResponse](ctx, r, url)
 	return isBp, err
 }
 
-func getBalance(baseUrl string, l2Address string) (iOwnerDetailsResponse, error) {
+func getBalance(ctx context.Context, r *requester, baseUrl string, l2Address string) (iOwnerDetailsResponse, error) {
 	url := fmt.Sprintf("%v%v?address=%v",
 		baseUrl,
 		ownerBalanceEndpoint,
 		l2Address,
 	)
-	ownerDetails, err := get[iOwnerDetailsResponse](url)
+	ownerDetails, err := get[iOwnerDetailsResponse](ctx, r, url)
 	return ownerDetails, err
 }
 
-func getL2Lookup(baseUrl string, l2AddressOrAlias string, network NetworkSymbol) (ILookForL2AddressResponse, error) {
+func getL2Lookup(ctx context.Context, r *requester, baseUrl string, l2AddressOrAlias string, network NetworkSymbol) (ILookForL2AddressResponse, error) {
 	url := fmt.Sprintf("%v%v?to=%v",
 		baseUrl,
 		l2LookupEndpoint,
-		l2AddressOrAlias,
+		url.QueryEscape(l2AddressOrAlias),
 	)
 	if network != "" {
 		url = fmt.Sprintf("%v&coinSymbol=%v",
@@ -126,49 +120,45 @@ func getL2Lookup(baseUrl string, l2AddressOrAlias string, network NetworkSymbol)
 			network,
 		)
 	}
-	l2Lookup, err := get[ILookForL2AddressResponse](url)
-
-	if strings.Contains(err.Error(), "connection refused") {
-		return ILookForL2AddressResponse{}, nil
-	}
+	l2Lookup, err := get[ILookForL2AddressResponse](ctx, r, url)
 	return l2Lookup, err
 }
 
-func prepareL1Txn(baseUrl string, payload prepareTxnDto) (iPrepareL1TxnResponse, error) {
+func prepareL1Txn(ctx context.Context, r *requester, baseUrl string, payload prepareTxnDto) (iPrepareL1TxnResponse, error) {
 	url := fmt.Sprintf("%v%v", baseUrl, prepareTxEndpoint)
-	return post[iPrepareL1TxnResponse](url, payload)
+	return post[iPrepareL1TxnResponse](ctx, r, url, payload)
 }
 
-func prepareL2Txn(baseUrl string, payload prepareL2TxnDto) (iPrepareL2TxnResponse, error) {
+func prepareL2Txn(ctx context.Context, r *requester, baseUrl string, payload prepareL2TxnDto) (iPrepareL2TxnResponse, error) {
 	url := fmt.Sprintf("%v%v", baseUrl, prepareL2TxnEndpoint)
-	return post[iPrepareL2TxnResponse](url, payload)
+	return post[iPrepareL2TxnResponse](ctx, r, url, payload)
 }
 
-func getSupportedCurrencies(baseUrl string) (map[CryptoCurrency][]NetworkSymbol, error) {
+func getSupportedCurrencies(ctx context.Context, r *requester, baseUrl string) (map[CryptoCurrency][]NetworkSymbol, error) {
 	url := fmt.Sprintf("%v%v",
 		baseUrl,
 		supportedCurrenciesEndpoint,
 	)
-	supportedCurrencies, err := get[map[CryptoCurrency][]NetworkSymbol](url)
+	supportedCurrencies, err := get[map[CryptoCurrency][]NetworkSymbol](ctx, r, url)
 
 	return supportedCurrencies, err
 }
 
-func getExchangeRates(baseUrl string, requestedCurrency Currency) (IGetExchangeRatesResult, error) {
+func getExchangeRates(ctx context.Context, r *requester, baseUrl string, requestedCurrency Currency) (IGetExchangeRatesResult, error) {
 	url := fmt.Sprintf("%v%v/%v",
 		baseUrl,
 		exchangeRatesEndpoint,
 		requestedCurrency,
 	)
-	exchangeRates, err := get[IGetExchangeRatesResult](url)
+	exchangeRates, err := get[IGetExchangeRatesResult](ctx, r, url)
 
 	return exchangeRates, err
 }
 
-func getTransfers(baseUrl string, identity string, params IGetTransactions) ([]ITransaction, error) {
+func getTransfers(ctx context.Context, r *requester, baseUrl string, identity string, params IGetTransactions) ([]ITransaction, error) {
 	query := getTransfersQueryParams(params, identity)
 	url := baseUrl + ownerTransactionEndpoint + "?" + query
-	resp, err := get[transactionsResponse](url)
+	resp, err := get[transactionsResponse](ctx, r, url)
 
 	transactions := resp.Transactions
 	return transactions, err
@@ -201,12 +191,12 @@ func getTransfersQueryParams(params IGetTransactions, identity string) string {
 		values = append(values, "hideSmallTransactions=true")
 	}
 	if params.Identifier != "" {
-		values = append(values, "identifier="+params.Identifier)
+		values = append(values, "identifier="+url.QueryEscape(params.Identifier))
 	}
 	if params.ReferenceId != "" {
-		values = append(values, "referenceId="+params.ReferenceId)
+		values = append(values, "referenceId="+url.QueryEscape(params.ReferenceId))
 	}
-	values = append(values, "identity="+identity)
+	values = append(values, "identity="+url.QueryEscape(identity))
 	return joinQueryParams(values)
 }
 
@@ -214,30 +204,30 @@ func joinQueryParams(params []string) string {
 	return strings.Join(params, "&")
 }
 
-func getTransactionDetails(baseUrl string, l2Hash string) (ITransaction, error) {
+func getTransactionDetails(ctx context.Context, r *requester, baseUrl string, l2Hash string) (ITransaction, error) {
 	url := fmt.Sprintf("%v%v?l2Hash=%v",
 		baseUrl,
 		transactionsDetailsEndpoint,
 		l2Hash,
 	)
-	response, err := get[l2HashTransactionResponse](url)
+	response, err := get[l2HashTransactionResponse](ctx, r, url)
 
 	t := response.Transaction
 	return t, err
 }
 
-func getByOwnerAndIdentifier(baseUrl string, coinSymbol NetworkSymbol, identifier string, identity string) (iGetByOwnerAndIdentifierResponse, error) {
+func getByOwnerAndIdentifier(ctx context.Context, r *requester, baseUrl string, coinSymbol NetworkSymbol, identifier string, identity string) (iGetByOwnerAndIdentifierResponse, error) {
 	url := fmt.Sprintf("%v%v?identity=%v&identifier=%v&coinSymbol=%v&usePreSeed=true",
 		baseUrl,
 		identifierLookupEndpoint,
-		identity,
-		identifier,
+		url.QueryEscape(identity),
+		url.QueryEscape(identifier),
 		coinSymbol,
 	)
-	return get[iGetByOwnerAndIdentifierResponse](url)
+	return get[iGetByOwnerAndIdentifierResponse](ctx, r, url)
 }
 
-func getByOwnerAndIdentifierKeys(baseUrl string, coinSymbols []NetworkSymbol, identifier string, identity string) ([]iGetByOwnerAndIdentifierKeysResponse, error) {
+func getByOwnerAndIdentifierKeys(ctx context.Context, r *requester, baseUrl string, coinSymbols []NetworkSymbol, identifier string, identity string) ([]iGetByOwnerAndIdentifierKeysResponse, error) {
 	params := url.Values{}
 	params.Set("identity", identity)
 	params.Set("identifier", identifier)
@@ -250,18 +240,20 @@ func getByOwnerAndIdentifierKeys(baseUrl string, coinSymbols []NetworkSymbol, id
 		identifierLookupsEndpoint,
 		params.Encode(),
 	)
-	return get[[]iGetByOwnerAndIdentifierKeysResponse](url)
+	return get[[]iGetByOwnerAndIdentifierKeysResponse](ctx, r, url)
 }
 
-func createDepositOrder(baseUrl string, payload iCreateDepositOrder) (iCreateDepositOrderResponse, error) {
+func createDepositOrder(ctx context.Context, r *requester, baseUrl string, payload iCreateDepositOrder) (iCreateDepositOrderResponse, error) {
 	url := fmt.Sprintf("%v%v", baseUrl, createDepositOrderEndpoint)
-	return post[iCreateDepositOrderResponse](url, payload)
+	return post[iCreateDepositOrderResponse](ctx, r, url, payload)
 }
 
 /**
  * Get all keys by BP and identifier
  */
 func getKeysByOwnerAndIdentifier(
+	ctx context.Context,
+	r *requester,
 	baseUrl string,
 	identity string,
 	identifier string,
@@ -270,5 +262,5 @@ func getKeysByOwnerAndIdentifier(
 	Params.Set("identity", identity)
 	Params.Set("identifier", identifier)
 	url := fmt.Sprintf("%v%v?%v", baseUrl, allKeysOfIdentifierEndpoint, Params.Encode())
-	return get[[]iKeyByOwnerAndIdentifierResponse](url)
+	return get[[]iKeyByOwnerAndIdentifierResponse](ctx, r, url)
 }
@@ -0,0 +1,155 @@
+package akashicpay
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// WithBalanceCheck makes payouts check the available balance before the
+// transaction is signed, and fail with *InsufficientBalanceError if it
+// doesn't cover the amount. The available balance is the total balance minus
+// pending payouts. Costs one request to AkashicScan per payout
+func WithBalanceCheck() Option {
+	return func(o *options) {
+		o.balanceCheck = true
+	}
+}
+
+// InsufficientBalanceError is returned when the available balance doesn't
+// cover a payout. errors.As also matches an *AkashicError with
+// AkashicErrorCodeSavingsExceeded
+type InsufficientBalanceError struct {
+	Network   NetworkSymbol
+	Token     TokenSymbol
+	Requested string // Amount requested, in the main unit
+	Available string // Total balance minus pending payouts, in the main unit
+}
+
+func (e *InsufficientBalanceError) Error() string {
+	return fmt.Sprintf("insufficient %v balance: requested %v, available %v", currencyName(e.Network, e.Token), e.Requested, e.Available)
+}
+
+func (e *InsufficientBalanceError) Unwrap() error {
+	return newAkashicError(AkashicErrorCodeSavingsExceeded, e.Error())
+}
+
+// balanceKey identifies the balance of one network and token
+type balanceKey struct {
+	Network NetworkSymbol
+	Token   TokenSymbol
+}
+
+func newBalanceKey(network NetworkSymbol, token TokenSymbol) balanceKey {
+	// USDT on Shasta may be reported under its AkashicChain name
+	if network == Tron_Shasta && token == mapUSDTToTether(Tron_Shasta, USDT) {
+		token = USDT
+	}
+	return balanceKey{network, token}
+}
+
+// availableBalances returns the total balance minus pending payouts per
+// network and token, in the smallest unit
+func (ap *AkashicPay) availableBalances(ctx context.Context) (map[balanceKey]*big.Int, error) {
+	ownerDetails, err := getBalance(ctx, ap.requester, ap.akashicUrl, ap.otk.Identity)
+	if err != nil {
+		return nil, err
+	}
+
+	available := make(map[balanceKey]*big.Int, len(ownerDetails.TotalBalances))
+	for _, b := range ownerDetails.TotalBalances {
+		key := newBalanceKey(b.CoinSymbol, b.TokenSymbol)
+		amount, err := smallestUnitInt(b.Amount, key.Network, key.Token)
+		if err != nil {
+			return nil, err
+		}
+		available[key] = amount
+	}
+	for _, b := range ownerDetails.PendingSendBalances {
+		key := newBalanceKey(b.CoinSymbol, b.TokenSymbol)
+		if available[key] == nil {
+			continue
+		}
+		pending, err := smallestUnitInt(b.Amount, key.Network, key.Token)
+		if err != nil {
+			return nil, err
+		}
+		available[key].Sub(available[key], pending)
+	}
+	return available, nil
+}
+
+// checkBalance returns *InsufficientBalanceError if amount, in the smallest
+// unit, exceeds the available balance
+func checkBalance(available map[balanceKey]*big.Int, network NetworkSymbol, token TokenSymbol, amount *big.Int) error {
+	balance := available[newBalanceKey(network, token)]
+	if balance == nil {
+		balance = new(big.Int)
+	}
+	if amount.Cmp(balance) <= 0 {
+		return nil
+	}
+	return &InsufficientBalanceError{
+		Network:   network,
+		Token:     token,
+		Requested: formatSmallestUnit(amount, network, token),
+		Available: formatSmallestUnit(balance, network, token),
+	}
+}
+
+// checkPayoutBalance fails if the available balance doesn't cover req
+func (ap *AkashicPay) checkPayoutBalance(ctx context.Context, req PayoutRequest) error {
+	amount, err := smallestUnitInt(req.Amount, req.Network, req.Token)
+	if err != nil {
+		return err
+	}
+	available, err := ap.availableBalances(ctx)
+	if err != nil {
+		return err
+	}
+	return checkBalance(available, req.Network, req.Token, amount)
+}
+
+// formatSmallestUnit converts amount from the smallest unit back to the main
+// unit, e.g. 1500000 USDT on Tron to "1.5"
+func formatSmallestUnit(amount *big.Int, network NetworkSymbol, token TokenSymbol) string {
+	decimals, err := getConversionFactor(network, token)
+	if err != nil || decimals <= 0 {
+		return amount.String()
+	}
+	digits := new(big.Int).Abs(amount).String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	whole, fraction := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	result := whole
+	if fraction != "" {
+		result += "." + fraction
+	}
+	if amount.Sign() < 0 {
+		result = "-" + result
+	}
+	return result
+}
+
+// smallestUnitInt is convertToSmallestUnit returning a *big.Int
+func smallestUnitInt(amount string, network NetworkSymbol, token TokenSymbol) (*big.Int, error) {
+	smallest, err := convertToSmallestUnit(amount, network, token)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := new(big.Int).SetString(smallest, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	return result, nil
+}
+
+// currencyName formats network and token for messages, e.g. "TRX/USDT"
+func currencyName(network NetworkSymbol, token TokenSymbol) string {
+	if token == "" {
+		return string(network)
+	}
+	return string(network) + "/" + string(token)
+}
@@ -0,0 +1,137 @@
+package akashicpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAvailableBalances(t *testing.T) {
+	serverUrl := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("address"); r.URL.Path != ownerBalanceEndpoint || got != testIdentity {
+			t.Errorf("unexpected request %v", r.URL)
+		}
+		json.NewEncoder(w).Encode(iOwnerDetailsResponse{
+			TotalBalances: []iOwnerBalancesResponse{
+				{CoinSymbol: Tron, Amount: "10.5"},
+				{CoinSymbol: Ethereum_Mainnet, TokenSymbol: USDT, Amount: "3"},
+				// Under its AkashicChain name
+				{CoinSymbol: Tron_Shasta, TokenSymbol: mapUSDTToTether(Tron_Shasta, USDT), Amount: "7"},
+			},
+			PendingSendBalances: []iOwnerBalancesResponse{
+				{CoinSymbol: Tron, Amount: "0.25"},
+				{CoinSymbol: Tron_Shasta, TokenSymbol: USDT, Amount: "2"},
+				// Without a total balance
+				{CoinSymbol: Solana, Amount: "1"},
+			},
+			// Not yet available
+			PendingDepositBalances: []iOwnerBalancesResponse{{CoinSymbol: Ethereum_Mainnet, TokenSymbol: USDT, Amount: "1"}},
+		})
+	}))
+	ap := newTestPay(t, serverUrl, WithoutBpCheck())
+
+	available, err := ap.availableBalances(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[balanceKey]string{
+		{Tron, ""}:               "10250000",
+		{Ethereum_Mainnet, USDT}: "3000000",
+		{Tron_Shasta, USDT}:      "5000000",
+	}
+	if len(available) != len(want) {
+		t.Errorf("availableBalances() = %v, want %v", available, want)
+	}
+	for key, amount := range want {
+		if got := available[key]; got == nil || got.String() != amount {
+			t.Errorf("available %v = %v, want %v", currencyName(key.Network, key.Token), got, amount)
+		}
+	}
+}
+
+func TestCheckBalance(t *testing.T) {
+	available := map[balanceKey]*big.Int{
+		{Tron, ""}:          big.NewInt(10_500_000),
+		{Tron_Shasta, USDT}: big.NewInt(-1_000_000), // More pending than the total
+	}
+	tests := []struct {
+		network       NetworkSymbol
+		token         TokenSymbol
+		amount        int64
+		wantAvailable string // "" if the balance suffices
+	}{
+		{Tron, "", 1, ""},
+		{Tron, "", 10_500_000, ""},
+		{Tron, "", 10_500_001, "10.5"},
+		{Tron_Shasta, USDT, 1, "-1"},
+		{Ethereum_Mainnet, USDT, 1, "0"},
+	}
+	for _, tt := range tests {
+		err := checkBalance(available, tt.network, tt.token, big.NewInt(tt.amount))
+		if tt.wantAvailable == "" {
+			if err != nil {
+				t.Errorf("checkBalance(%v %v) = %v, want nil", tt.amount, currencyName(tt.network, tt.token), err)
+			}
+			continue
+		}
+		var insufficient *InsufficientBalanceError
+		var akashicErr *AkashicError
+		if !errors.As(err, &insufficient) || insufficient.Available != tt.wantAvailable {
+			t.Errorf("checkBalance(%v %v) = %v, want %v available", tt.amount, currencyName(tt.network, tt.token), err, tt.wantAvailable)
+		} else if !errors.As(err, &akashicErr) || akashicErr.Code != AkashicErrorCodeSavingsExceeded {
+			t.Errorf("checkBalance() = %v, want it to match %v", err, AkashicErrorCodeSavingsExceeded)
+		}
+	}
+}
+
+func TestFormatSmallestUnit(t *testing.T) {
+	tests := []struct {
+		amount  int64
+		network NetworkSymbol
+		token   TokenSymbol
+		want    string
+	}{
+		{1_500_000, Tron, "", "1.5"},
+		{1, Tron, "", "0.000001"},
+		{0, Tron, "", "0"},
+		{-2_000_000, Tron, USDT, "-2"},
+		{123, "DOGE", "", "123"}, // Unknown decimals
+	}
+	for _, tt := range tests {
+		if got := formatSmallestUnit(big.NewInt(tt.amount), tt.network, tt.token); got != tt.want {
+			t.Errorf("formatSmallestUnit(%v, %v) = %v, want %v", tt.amount, currencyName(tt.network, tt.token), got, tt.want)
+		}
+	}
+}
+
+func TestPayoutBalanceCheck(t *testing.T) {
+	var prepares atomic.Int32
+	serverUrl := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case ownerBalanceEndpoint:
+			json.NewEncoder(w).Encode(iOwnerDetailsResponse{
+				TotalBalances:       []iOwnerBalancesResponse{{CoinSymbol: Tron, Amount: "5"}},
+				PendingSendBalances: []iOwnerBalancesResponse{{CoinSymbol: Tron, Amount: "4"}},
+			})
+		case prepareTxEndpoint:
+			prepares.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	ap := newTestPay(t, serverUrl, WithoutBpCheck(), WithBalanceCheck())
+
+	_, err := ap.PayoutWithOptions(context.Background(), PayoutRequest{ReferenceId: "ref-1", To: testTronAddress, Amount: "2", Network: Tron})
+	var insufficient *InsufficientBalanceError
+	if !errors.As(err, &insufficient) || insufficient.Requested != "2" || insufficient.Available != "1" {
+		t.Fatalf("PayoutWithOptions() = %v, want 2 requested and 1 available", err)
+	}
+	if got := prepares.Load(); got != 0 {
+		t.Errorf("payout prepared %d times, want none", got)
+	}
+}
@@ -0,0 +1,197 @@
+package akashicpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// BatchOptions configures PayoutBatch
+type BatchOptions struct {
+	// Payouts made at the same time. Defaults to 1
+	Concurrency int
+	// Don't start further payouts after one failed. They are reported as
+	// skipped
+	StopOnError bool
+	// Called after each payout finished, failed or was skipped. Calls are
+	// never concurrent
+	OnProgress func(BatchProgress)
+}
+
+// BatchProgress reports the progress of PayoutBatch
+type BatchProgress struct {
+	Index     int             // Index of the request that finished
+	Item      BatchItemResult // Outcome of that request
+	Completed int             // Requests finished so far, including failed ones
+	Failed    int             // Requests failed or skipped so far
+	Total     int             // Requests in the batch
+}
+
+// BatchItemResult is the outcome of one request of PayoutBatch
+type BatchItemResult struct {
+	Request PayoutRequest
+	Result  PayoutResult // Zero-valued if Err is set
+	Err     error
+}
+
+// PayoutBatch makes all payouts in reqs, at most opts.Concurrency at a
+// time. The results are in the order of reqs
+//
+// The whole batch is validated first: amounts and decimals, unique
+// referenceIds, that every receiver can be resolved, that the payout
+// policies allow every payout, and that the available balance covers the
+// total per network and token. If validation fails nothing is paid out and
+// only the error is returned. Otherwise the error is nil, and the outcome of
+// each payout is in its BatchItemResult
+func (ap *AkashicPay) PayoutBatch(ctx context.Context, reqs []PayoutRequest, opts BatchOptions) (results []BatchItemResult, err error) {
+	ctx, end := ap.startOperation(ctx, "PayoutBatch")
+	defer func() { end(err) }()
+
+	concurrency := max(opts.Concurrency, 1)
+	if err := ap.validateBatch(ctx, reqs, concurrency); err != nil {
+		return nil, err
+	}
+
+	results = make([]BatchItemResult, len(reqs))
+	var mu sync.Mutex // Guards progress, stopped and calls to OnProgress
+	progress := BatchProgress{Total: len(reqs)}
+	stopped := false
+	finish := func(i int, item BatchItemResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[i] = item
+		progress.Completed++
+		if item.Err != nil {
+			progress.Failed++
+			if opts.StopOnError {
+				stopped = true
+			}
+		}
+		if opts.OnProgress != nil {
+			progress.Index = i
+			progress.Item = item
+			opts.OnProgress(progress)
+		}
+	}
+	isStopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return stopped
+	}
+
+	forEachConcurrently(len(reqs), concurrency, func(i int) {
+		req := reqs[i]
+		if err := ctx.Err(); err != nil {
+			finish(i, BatchItemResult{Request: req, Err: err})
+			return
+		}
+		if isStopped() {
+			finish(i, BatchItemResult{Request: req, Err: newAkashicError(AkashicErrorCodePayoutSkipped, "")})
+			return
+		}
+		result, err := ap.PayoutWithOptions(ctx, req)
+		finish(i, BatchItemResult{Request: req, Result: result, Err: err})
+	})
+	return results, nil
+}
+
+// validateBatch checks reqs as described in PayoutBatch. The returned error
+// lists every invalid request
+func (ap *AkashicPay) validateBatch(ctx context.Context, reqs []PayoutRequest, concurrency int) error {
+	var errs []error
+	seen := make(map[string]int, len(reqs))
+	for i, req := range reqs {
+		if err := req.validate(); err != nil {
+			errs = append(errs, batchItemError(i, req, err))
+			continue
+		}
+		if first, ok := seen[req.ReferenceId]; ok {
+			errs = append(errs, batchItemError(i, req, fmt.Errorf("duplicate referenceId, also used by payout %d", first)))
+			continue
+		}
+		seen[req.ReferenceId] = i
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid payout batch: %w", errors.Join(errs...))
+	}
+
+	policyPayouts := make([]PolicyPayout, len(reqs))
+	resolveErrs := make([]error, len(reqs))
+	forEachConcurrently(len(reqs), concurrency, func(i int) {
+		recipient, err := ap.resolvePayout(ctx, reqs[i])
+		if err != nil {
+			resolveErrs[i] = batchItemError(i, reqs[i], err)
+			return
+		}
+		policyPayouts[i] = newPolicyPayout(reqs[i], recipient)
+	})
+	if err := errors.Join(resolveErrs...); err != nil {
+		return fmt.Errorf("invalid payout batch: %w", err)
+	}
+
+	if err := ap.checkBatchPolicies(ctx, policyPayouts); err != nil {
+		return fmt.Errorf("invalid payout batch: %w", err)
+	}
+
+	if err := ap.checkBatchBalance(ctx, reqs); err != nil {
+		return fmt.Errorf("invalid payout batch: %w", err)
+	}
+	return nil
+}
+
+// checkBatchBalance checks that the available balance covers the total of
+// reqs per network and token
+func (ap *AkashicPay) checkBatchBalance(ctx context.Context, reqs []PayoutRequest) error {
+	totals := make(map[balanceKey]*big.Int)
+	var order []balanceKey
+	for _, req := range reqs {
+		amount, err := smallestUnitInt(req.Amount, req.Network, req.Token)
+		if err != nil {
+			return err
+		}
+		key := balanceKey{req.Network, req.Token}
+		if totals[key] == nil {
+			totals[key] = new(big.Int)
+			order = append(order, key)
+		}
+		totals[key].Add(totals[key], amount)
+	}
+
+	available, err := ap.availableBalances(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, key := range order {
+		errs = append(errs, checkBalance(available, key.Network, key.Token, totals[key]))
+	}
+	return errors.Join(errs...)
+}
+
+func batchItemError(i int, req PayoutRequest, err error) error {
+	return fmt.Errorf("payout %d (referenceId %q): %w", i, req.ReferenceId, err)
+}
+
+// forEachConcurrently calls fn for 0 to n-1, with at most concurrency calls
+// at a time, in order of i. Returns once all calls returned
+func forEachConcurrently(n int, concurrency int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
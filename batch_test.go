@@ -0,0 +1,249 @@
+package akashicpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// batchTestServer stands in for AkashicScan and an AC node. Payouts whose
+// referenceId starts with "fail" are rejected by AkashicScan
+type batchTestServer struct {
+	url         string
+	balance     string // TRX balance on Tron
+	prepares    atomic.Int32
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func newBatchTestServer(t *testing.T, balance string) *batchTestServer {
+	t.Helper()
+	s := &batchTestServer{balance: balance}
+	s.url = newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == l2LookupEndpoint:
+			w.Write([]byte(`{}`))
+		case r.URL.Path == ownerBalanceEndpoint:
+			json.NewEncoder(w).Encode(iOwnerDetailsResponse{
+				TotalBalances: []iOwnerBalancesResponse{{CoinSymbol: Tron, Amount: s.balance}},
+			})
+		case r.URL.Path == prepareTxEndpoint:
+			s.prepare(w, r)
+		case r.Method == http.MethodGet:
+			fmt.Fprintf(w, `{"status": %d}`, acNodeHealthyStatus)
+		default:
+			fmt.Fprintf(w, `{"$umid": %q, "$summary": {"total": 1, "vote": 1, "commit": 1}}`, strings.Repeat("a", 64))
+		}
+	}))
+	return s
+}
+
+func (s *batchTestServer) prepare(w http.ResponseWriter, r *http.Request) {
+	s.prepares.Add(1)
+	inFlight := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		highest := s.maxInFlight.Load()
+		if inFlight <= highest || s.maxInFlight.CompareAndSwap(highest, inFlight) {
+			break
+		}
+	}
+	// Give other payouts the chance to overlap
+	time.Sleep(5 * time.Millisecond)
+
+	var payload prepareTxnDto
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if strings.HasPrefix(payload.ReferenceId, "fail") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	tx := l1Transaction(EnvironmentConfig{}, payload.Identity, payload.NetworkSymbol, payload.Amount, payload.ToAddress, payload.TokenSymbol, payload.ReferenceId, payload.FeeDelegationStrategy, nil, time.Minute)
+	json.NewEncoder(w).Encode(iPrepareL1TxnResponse{PreparedTxn: tx})
+}
+
+func batchRequests(referenceIds ...string) []PayoutRequest {
+	reqs := make([]PayoutRequest, len(referenceIds))
+	for i, referenceId := range referenceIds {
+		reqs[i] = PayoutRequest{ReferenceId: referenceId, To: testTronAddress, Amount: "1", Network: Tron}
+	}
+	return reqs
+}
+
+func TestForEachConcurrently(t *testing.T) {
+	for _, concurrency := range []int{1, 3, 50} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			const n = 20
+			var calls [n]atomic.Int32
+			var inFlight, maxInFlight atomic.Int32
+			forEachConcurrently(n, concurrency, func(i int) {
+				calls[i].Add(1)
+				current := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					highest := maxInFlight.Load()
+					if current <= highest || maxInFlight.CompareAndSwap(highest, current) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+			})
+
+			for i := range calls {
+				if got := calls[i].Load(); got != 1 {
+					t.Errorf("fn(%d) called %d times, want once", i, got)
+				}
+			}
+			if got := maxInFlight.Load(); got > int32(concurrency) {
+				t.Errorf("%d calls at a time, want at most %d", got, concurrency)
+			}
+		})
+	}
+}
+
+func TestPayoutBatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		referenceIds []string
+		opts         BatchOptions
+		wantPrepares int32
+		// Per request, "" if paid out, else the error code or "error"
+		want []AkashicErrorCode
+	}{
+		{
+			name:         "all paid",
+			referenceIds: []string{"ref-1", "ref-2", "ref-3", "ref-4", "ref-5", "ref-6"},
+			opts:         BatchOptions{Concurrency: 3},
+			wantPrepares: 6,
+			want:         []AkashicErrorCode{"", "", "", "", "", ""},
+		},
+		{
+			name:         "continue on error",
+			referenceIds: []string{"ref-1", "fail-2", "ref-3", "ref-4"},
+			wantPrepares: 4,
+			want:         []AkashicErrorCode{"", "error", "", ""},
+		},
+		{
+			name:         "stop on error",
+			referenceIds: []string{"ref-1", "fail-2", "ref-3", "ref-4"},
+			opts:         BatchOptions{StopOnError: true},
+			wantPrepares: 2,
+			want:         []AkashicErrorCode{"", "error", AkashicErrorCodePayoutSkipped, AkashicErrorCodePayoutSkipped},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newBatchTestServer(t, "100")
+			ap := newTestPay(t, server.url, WithoutBpCheck())
+			reqs := batchRequests(tt.referenceIds...)
+
+			var progress []BatchProgress
+			opts := tt.opts
+			opts.OnProgress = func(p BatchProgress) { progress = append(progress, p) }
+			results, err := ap.PayoutBatch(context.Background(), reqs, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantFailed := 0
+			for i, result := range results {
+				if result.Request.ReferenceId != reqs[i].ReferenceId {
+					t.Errorf("result %d is of %v, want %v", i, result.Request.ReferenceId, reqs[i].ReferenceId)
+				}
+				var akashicErr *AkashicError
+				switch want := tt.want[i]; {
+				case want == "" && (result.Err != nil || result.Result.L2Hash == ""):
+					t.Errorf("result %d = %+v, %v, want paid out", i, result.Result, result.Err)
+				case want == "error" && result.Err == nil:
+					t.Errorf("result %d error = nil, want an error", i)
+				case want != "" && want != "error" && (!errors.As(result.Err, &akashicErr) || akashicErr.Code != want):
+					t.Errorf("result %d error = %v, want %v", i, result.Err, want)
+				}
+				if tt.want[i] != "" {
+					wantFailed++
+				}
+			}
+			if got := server.prepares.Load(); got != tt.wantPrepares {
+				t.Errorf("%d payouts prepared, want %d", got, tt.wantPrepares)
+			}
+			if got := server.maxInFlight.Load(); got > int32(max(tt.opts.Concurrency, 1)) {
+				t.Errorf("%d payouts at a time, want at most %d", got, max(tt.opts.Concurrency, 1))
+			}
+
+			if len(progress) != len(reqs) {
+				t.Fatalf("OnProgress called %d times, want %d", len(progress), len(reqs))
+			}
+			for i, p := range progress {
+				if p.Completed != i+1 || p.Total != len(reqs) || p.Item.Request.ReferenceId != reqs[p.Index].ReferenceId {
+					t.Errorf("progress %d = %+v", i, p)
+				}
+			}
+			if last := progress[len(progress)-1]; last.Failed != wantFailed {
+				t.Errorf("failed = %d, want %d", last.Failed, wantFailed)
+			}
+		})
+	}
+}
+
+func TestPayoutBatchValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		balance   string
+		edit      func(reqs []PayoutRequest)
+		wantError string
+	}{
+		{
+			name:      "invalid amount",
+			balance:   "100",
+			edit:      func(reqs []PayoutRequest) { reqs[1].Amount = "1.0000001" },
+			wantError: "payout 1",
+		},
+		{
+			name:      "duplicate referenceId",
+			balance:   "100",
+			edit:      func(reqs []PayoutRequest) { reqs[2].ReferenceId = reqs[0].ReferenceId },
+			wantError: "payout 2",
+		},
+		{
+			name:      "unknown alias",
+			balance:   "100",
+			edit:      func(reqs []PayoutRequest) { reqs[1].To = "unknown-alias" },
+			wantError: "payout 1",
+		},
+		{
+			// Each payout is covered, but not all of them
+			name:      "insufficient balance",
+			balance:   "2.5",
+			wantError: "insufficient",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newBatchTestServer(t, tt.balance)
+			ap := newTestPay(t, server.url, WithoutBpCheck())
+			reqs := batchRequests("ref-1", "ref-2", "ref-3")
+			if tt.edit != nil {
+				tt.edit(reqs)
+			}
+
+			results, err := ap.PayoutBatch(context.Background(), reqs, BatchOptions{Concurrency: 3})
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Fatalf("PayoutBatch() = %v, want an error mentioning %q", err, tt.wantError)
+			}
+			if results != nil {
+				t.Errorf("results = %v, want nil", results)
+			}
+			if got := server.prepares.Load(); got != 0 {
+				t.Errorf("%d payouts prepared, want none", got)
+			}
+		})
+	}
+}
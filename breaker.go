@@ -0,0 +1,198 @@
+package akashicpay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // Requests are sent
+	CircuitOpen                         // Requests fail fast with AkashicErrorCodeCircuitOpen
+	CircuitHalfOpen                     // Trial requests are sent to check if the endpoint recovered
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerConfig configures the circuit breakers of an instance. Each
+// endpoint, i.e. method and URL without query, has its own breaker. Network
+// errors, timeouts and 5xx responses count as failures
+type CircuitBreakerConfig struct {
+	FailureThreshold    int           // Consecutive failures that open the circuit. Defaults to 5
+	OpenTimeout         time.Duration // How long the circuit stays open before trial requests. Defaults to 30 seconds
+	HalfOpenMaxRequests int           // Concurrent trial requests while half-open. Defaults to 1
+	// Called whenever the circuit of an endpoint changes state. Must not
+	// block
+	OnStateChange func(endpoint string, from CircuitState, to CircuitState)
+}
+
+// WithCircuitBreaker enables a circuit breaker per upstream endpoint, so
+// calls to an endpoint that is down fail fast instead of each waiting for a
+// timeout
+func WithCircuitBreaker(config CircuitBreakerConfig) Option {
+	return func(o *options) {
+		o.circuitBreaker = &config
+	}
+}
+
+// circuitBreakers holds the breakers of all endpoints. Safe for concurrent
+// use
+type circuitBreakers struct {
+	config   CircuitBreakerConfig
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(config CircuitBreakerConfig) *circuitBreakers {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenMaxRequests <= 0 {
+		config.HalfOpenMaxRequests = 1
+	}
+	return &circuitBreakers{config: config, breakers: make(map[string]*circuitBreaker)}
+}
+
+func (c *circuitBreakers) forEndpoint(endpoint string) *circuitBreaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	breaker, ok := c.breakers[endpoint]
+	if !ok {
+		breaker = &circuitBreaker{endpoint: endpoint, config: &c.config}
+		c.breakers[endpoint] = breaker
+	}
+	return breaker
+}
+
+type circuitBreaker struct {
+	endpoint string
+	config   *CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int       // Consecutive failures while closed
+	openedAt time.Time // When the circuit last opened
+	inFlight int       // Trial requests while half-open
+	// Incremented on every state change, so outcomes of requests allowed in
+	// an earlier state are ignored
+	generation uint64
+}
+
+// circuitTicket is handed out by allow for a request and passed back to
+// done with its outcome
+type circuitTicket struct {
+	generation uint64
+}
+
+// allow reports whether a request may be sent. Every allowed request must
+// be followed by a call to done with the returned ticket
+func (b *circuitBreaker) allow() (circuitTicket, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen {
+		if time.Since(b.openedAt) < b.config.OpenTimeout {
+			return circuitTicket{}, newAkashicError(AkashicErrorCodeCircuitOpen, "circuit open for "+b.endpoint)
+		}
+		b.setState(CircuitHalfOpen)
+	}
+	if b.state == CircuitHalfOpen {
+		if b.inFlight >= b.config.HalfOpenMaxRequests {
+			return circuitTicket{}, newAkashicError(AkashicErrorCodeCircuitOpen, "circuit half-open for "+b.endpoint)
+		}
+		b.inFlight++
+	}
+	return circuitTicket{generation: b.generation}, nil
+}
+
+// done records the outcome of a request allowed by allow. Outcomes of
+// requests allowed before the last state change are ignored
+func (b *circuitBreaker) done(ticket circuitTicket, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ticket.generation != b.generation {
+		return
+	}
+	switch b.state {
+	case CircuitHalfOpen:
+		b.inFlight--
+		if failed {
+			b.open()
+		} else {
+			b.failures = 0
+			b.setState(CircuitClosed)
+		}
+	case CircuitClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.config.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.failures = 0
+	b.setState(CircuitOpen)
+}
+
+func (b *circuitBreaker) setState(state CircuitState) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	b.generation++
+	if state == CircuitHalfOpen {
+		b.inFlight = 0
+	}
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(b.endpoint, from, state)
+	}
+}
+
+// countsAsCircuitFailure reports whether err indicates the endpoint is
+// unhealthy. Errors caused by the request, or by the caller cancelling it,
+// don't count
+func countsAsCircuitFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || isConnectionRefused(err)
+}
+
+// isCircuitOpen reports whether err was returned without sending the request
+// because the circuit of the endpoint is open
+func isCircuitOpen(err error) bool {
+	var akashicErr *AkashicError
+	return errors.As(err, &akashicErr) && akashicErr.Code == AkashicErrorCodeCircuitOpen
+}
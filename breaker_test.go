@@ -0,0 +1,125 @@
+package akashicpay
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	return newCircuitBreakers(config).forEndpoint("GET /test")
+}
+
+// expire makes an open circuit ready for trial requests
+func (b *circuitBreaker) expire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.openedAt = time.Now().Add(-b.config.OpenTimeout)
+}
+
+func mustAllow(t *testing.T, b *circuitBreaker) circuitTicket {
+	t.Helper()
+	ticket, err := b.allow()
+	if err != nil {
+		t.Fatalf("allow() = %v, want nil", err)
+	}
+	return ticket
+}
+
+func mustReject(t *testing.T, b *circuitBreaker) {
+	t.Helper()
+	if _, err := b.allow(); !isCircuitOpen(err) {
+		t.Fatalf("allow() = %v, want circuit open", err)
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var transitions []CircuitState
+	b := newTestBreaker(CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Hour,
+		OnStateChange: func(endpoint string, from CircuitState, to CircuitState) {
+			transitions = append(transitions, to)
+		},
+	})
+
+	b.done(mustAllow(t, b), true)
+	b.done(mustAllow(t, b), false) // Resets the consecutive failures
+	b.done(mustAllow(t, b), true)
+	if b.state != CircuitClosed {
+		t.Fatalf("state = %v after non-consecutive failures, want closed", b.state)
+	}
+	b.done(mustAllow(t, b), true)
+	if b.state != CircuitOpen {
+		t.Fatalf("state = %v after consecutive failures, want open", b.state)
+	}
+	mustReject(t, b)
+
+	// A failed trial opens the circuit again
+	b.expire()
+	b.done(mustAllow(t, b), true)
+	if b.state != CircuitOpen {
+		t.Fatalf("state = %v after failed trial, want open", b.state)
+	}
+
+	// A successful trial closes it
+	b.expire()
+	b.done(mustAllow(t, b), false)
+	if b.state != CircuitClosed {
+		t.Fatalf("state = %v after successful trial, want closed", b.state)
+	}
+
+	want := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("transitions = %v, want %v", transitions, want)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenLimit(t *testing.T) {
+	b := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour, HalfOpenMaxRequests: 2})
+	b.done(mustAllow(t, b), true)
+
+	for cycle := range 3 {
+		b.expire()
+		first := mustAllow(t, b)
+		second := mustAllow(t, b)
+		mustReject(t, b)
+
+		// The first trial fails and opens the circuit. The outcome of the
+		// second must not leak its slot into the next half-open period
+		b.done(first, true)
+		b.done(second, false)
+		if b.state != CircuitOpen {
+			t.Fatalf("cycle %d: state = %v, want open", cycle, b.state)
+		}
+	}
+	b.expire()
+	mustAllow(t, b)
+	mustAllow(t, b)
+}
+
+func TestCircuitBreakerIgnoresStaleOutcomes(t *testing.T) {
+	b := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour})
+
+	slow := mustAllow(t, b) // Allowed while closed
+	b.done(mustAllow(t, b), true)
+	b.expire()
+	trial := mustAllow(t, b)
+
+	// The slow request finishing must neither close the circuit nor free
+	// the slot of the trial
+	b.done(slow, false)
+	if b.state != CircuitHalfOpen {
+		t.Fatalf("state = %v after stale outcome, want half-open", b.state)
+	}
+	mustReject(t, b)
+
+	b.done(trial, false)
+	if b.state != CircuitClosed {
+		t.Fatalf("state = %v after successful trial, want closed", b.state)
+	}
+}
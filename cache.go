@@ -0,0 +1,235 @@
+package akashicpay
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache stores slow-changing data fetched by an AkashicPay instance.
+// Implementations must be safe for concurrent use. Values are stored and
+// returned as is
+type Cache interface {
+	// Get returns the value stored under key, unless it has expired
+	Get(key string) (any, bool)
+	// Set stores value under key until ttl has passed
+	Set(key string, value any, ttl time.Duration)
+	// DeletePrefix removes all values whose key starts with prefix
+	DeletePrefix(prefix string)
+}
+
+// CacheKind is a type of data that can be cached
+type CacheKind string
+
+const (
+	CacheSupportedCurrencies CacheKind = "supportedCurrencies"
+	CacheExchangeRates       CacheKind = "exchangeRates"
+	CacheL2Lookup            CacheKind = "l2Lookup"
+)
+
+var cacheKinds = []CacheKind{CacheSupportedCurrencies, CacheExchangeRates, CacheL2Lookup}
+
+// CacheTTL sets how long each kind of data is cached. A zero TTL disables
+// caching for that kind
+type CacheTTL struct {
+	SupportedCurrencies time.Duration // GetSupportedCurrencies, also used for deposit URLs
+	ExchangeRates       time.Duration // GetExchangeRates
+	// LookForL2Address, also used by Payout. Note that a recipient who
+	// registers an L2-address is only paid on L2 once the TTL has passed
+	L2Lookup time.Duration
+}
+
+// DefaultCacheTTL caches supported currencies for 10 minutes, exchange rates
+// for 30 seconds and L2 lookups for 5 minutes
+var DefaultCacheTTL = CacheTTL{
+	SupportedCurrencies: 10 * time.Minute,
+	ExchangeRates:       30 * time.Second,
+	L2Lookup:            5 * time.Minute,
+}
+
+func (t CacheTTL) forKind(kind CacheKind) time.Duration {
+	switch kind {
+	case CacheSupportedCurrencies:
+		return t.SupportedCurrencies
+	case CacheExchangeRates:
+		return t.ExchangeRates
+	case CacheL2Lookup:
+		return t.L2Lookup
+	default:
+		return 0
+	}
+}
+
+// CacheStat counts the cache hits and misses of one kind of data
+type CacheStat struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// WithCache enables caching with the given TTLs. Data is cached in memory
+// unless a store is set with WithCacheStore. By default nothing is cached
+func WithCache(ttl CacheTTL) Option {
+	return func(o *options) {
+		o.cacheTTL = ttl
+	}
+}
+
+// WithCacheStore sets the Cache used by WithCache, e.g. to share it between
+// instances. Instances only share data if they use the same AkashicScan URL
+func WithCacheStore(cache Cache) Option {
+	return func(o *options) {
+		o.cacheStore = cache
+	}
+}
+
+// responseCache wraps the configured Cache with TTLs and statistics
+type responseCache struct {
+	store Cache
+	// Keeps apart the data of instances sharing store that use different
+	// environments
+	prefix string
+	ttl    CacheTTL
+	hits   map[CacheKind]*atomic.Uint64
+	misses map[CacheKind]*atomic.Uint64
+}
+
+func newResponseCache(store Cache, ttl CacheTTL, akashicUrl string) *responseCache {
+	if store == nil {
+		store = NewMemoryCache()
+	}
+	c := &responseCache{
+		store:  store,
+		prefix: akashicUrl + "|",
+		ttl:    ttl,
+		hits:   make(map[CacheKind]*atomic.Uint64, len(cacheKinds)),
+		misses: make(map[CacheKind]*atomic.Uint64, len(cacheKinds)),
+	}
+	for _, kind := range cacheKinds {
+		c.hits[kind] = new(atomic.Uint64)
+		c.misses[kind] = new(atomic.Uint64)
+	}
+	return c
+}
+
+func (c *responseCache) key(kind CacheKind, key string) string {
+	return c.prefix + string(kind) + ":" + key
+}
+
+// cached returns the value of kind stored under key, or loads and stores it
+func cached[T any](ctx context.Context, c *responseCache, kind CacheKind, key string, load func(context.Context) (T, error)) (T, error) {
+	ttl := c.ttl.forKind(kind)
+	if ttl <= 0 {
+		return load(ctx)
+	}
+
+	if value, ok := c.store.Get(c.key(kind, key)); ok {
+		if result, ok := value.(T); ok {
+			c.hits[kind].Add(1)
+			return result, nil
+		}
+	}
+	c.misses[kind].Add(1)
+
+	result, err := load(ctx)
+	if err != nil {
+		return result, err
+	}
+	c.store.Set(c.key(kind, key), result, ttl)
+	return result, nil
+}
+
+// InvalidateCache removes the cached data of the given kinds, or of all
+// kinds if none are given
+func (ap *AkashicPay) InvalidateCache(kinds ...CacheKind) {
+	if len(kinds) == 0 {
+		kinds = cacheKinds
+	}
+	for _, kind := range kinds {
+		ap.cache.store.DeletePrefix(ap.cache.key(kind, ""))
+	}
+}
+
+// CacheStats returns the cache hits and misses of each kind of data since
+// the instance was created
+func (ap *AkashicPay) CacheStats() map[CacheKind]CacheStat {
+	stats := make(map[CacheKind]CacheStat, len(cacheKinds))
+	for _, kind := range cacheKinds {
+		stats[kind] = CacheStat{
+			Hits:   ap.cache.hits[kind].Load(),
+			Misses: ap.cache.misses[kind].Load(),
+		}
+	}
+	return stats
+}
+
+// MemoryCache is an in-memory Cache. Expired values are removed when they
+// are next read or overwritten, and all at once whenever the number of
+// values has doubled since they were last removed
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+	sweepAt int // Number of values at which Set removes the expired ones
+}
+
+// Fewest values at which MemoryCache removes the expired ones
+const memoryCacheMinSweep = 64
+
+type memoryCacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+// NewMemoryCache returns an empty MemoryCache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryCacheEntry), sweepAt: memoryCacheMinSweep}
+}
+
+func (c *MemoryCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *MemoryCache) Set(key string, value any, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.entries[key] = memoryCacheEntry{value: value, expires: now.Add(ttl)}
+	if len(c.entries) < c.sweepAt {
+		return
+	}
+	maps.DeleteFunc(c.entries, func(_ string, entry memoryCacheEntry) bool {
+		return now.After(entry.expires)
+	})
+	c.sweepAt = max(2*len(c.entries), memoryCacheMinSweep)
+}
+
+func (c *MemoryCache) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	maps.DeleteFunc(c.entries, func(key string, _ memoryCacheEntry) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// cloneSupportedCurrencies copies currencies so callers can't modify the
+// cached value
+func cloneSupportedCurrencies(currencies map[CryptoCurrency][]NetworkSymbol) map[CryptoCurrency][]NetworkSymbol {
+	clone := make(map[CryptoCurrency][]NetworkSymbol, len(currencies))
+	for currency, networks := range currencies {
+		clone[currency] = slices.Clone(networks)
+	}
+	return clone
+}
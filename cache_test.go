@@ -0,0 +1,72 @@
+package akashicpay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestL2LookupFallbackNotCached(t *testing.T) {
+	// A closed server refuses connections
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	ap := newTestPay(t, server.URL, WithoutBpCheck(), WithCache(DefaultCacheTTL))
+
+	lookup, err := ap.LookForL2AddressContext(context.Background(), "alias", Tron_Shasta)
+	if err != nil || lookup.L2Address != "" {
+		t.Fatalf("LookForL2AddressContext() = %+v, %v, want an empty lookup", lookup, err)
+	}
+	if _, ok := ap.cache.store.Get(ap.cache.key(CacheL2Lookup, string(Tron_Shasta)+":alias")); ok {
+		t.Error("fallback lookup was cached")
+	}
+}
+
+func TestSharedCacheKeepsEnvironmentsApart(t *testing.T) {
+	currencies := func(network NetworkSymbol) string {
+		return newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[CryptoCurrency][]NetworkSymbol{CryptoUSDT: {network}})
+		}))
+	}
+	store := NewMemoryCache()
+	mainnet := newTestPay(t, currencies(Tron), WithoutBpCheck(), WithCache(DefaultCacheTTL), WithCacheStore(store))
+	testnet := newTestPay(t, currencies(Tron_Shasta), WithoutBpCheck(), WithCache(DefaultCacheTTL), WithCacheStore(store))
+
+	ctx := context.Background()
+	for _, tt := range []struct {
+		ap   *AkashicPay
+		want NetworkSymbol
+	}{{mainnet, Tron}, {testnet, Tron_Shasta}, {mainnet, Tron}} {
+		got, err := tt.ap.supportedCurrencies(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if networks := got[CryptoUSDT]; len(networks) != 1 || networks[0] != tt.want {
+			t.Errorf("supportedCurrencies() = %v, want %v", got, tt.want)
+		}
+	}
+
+	// Invalidating only affects the instance's own data
+	testnet.InvalidateCache()
+	if _, ok := store.Get(mainnet.cache.key(CacheSupportedCurrencies, "")); !ok {
+		t.Error("InvalidateCache() removed the data of another environment")
+	}
+}
+
+func TestMemoryCacheSweepsExpiredValues(t *testing.T) {
+	cache := NewMemoryCache()
+	for i := range 1000 {
+		cache.Set(strconv.Itoa(i), i, time.Nanosecond)
+	}
+	cache.Set("live", true, time.Hour)
+	if n := len(cache.entries); n >= memoryCacheMinSweep*2 {
+		t.Errorf("%v values kept, want expired ones removed", n)
+	}
+	if _, ok := cache.Get("live"); !ok {
+		t.Error("live value was removed")
+	}
+}
@@ -0,0 +1,123 @@
+package akashicpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WaitOptions configures WaitForConfirmation. Zero values use the defaults
+type WaitOptions struct {
+	InitialInterval time.Duration // Delay before the second poll. Defaults to 2 seconds
+	MaxInterval     time.Duration // Upper bound of the delay between polls. Defaults to 30 seconds
+	Multiplier      float64       // Growth of the delay after each poll. Defaults to 1.5
+	// How long to wait in total. Defaults to no limit other than the
+	// deadline of ctx
+	Timeout time.Duration
+}
+
+// ConfirmationTimeoutError is returned by WaitForConfirmation if the
+// transaction is still pending, or not yet indexed, when the wait ends
+type ConfirmationTimeoutError struct {
+	L2Hash string
+	// Last known state of the transaction. Zero-valued if AkashicScan has
+	// not indexed it yet
+	Transaction ITransaction
+	Err         error // Why the wait ended, context.DeadlineExceeded or context.Canceled
+}
+
+func (e *ConfirmationTimeoutError) Error() string {
+	if e.Transaction.Status == "" {
+		return fmt.Sprintf("transaction %v not indexed yet: %v", e.L2Hash, e.Err)
+	}
+	return fmt.Sprintf("transaction %v still %v: %v", e.L2Hash, e.Transaction.Status, e.Err)
+}
+
+func (e *ConfirmationTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// TransactionFailedError is returned by WaitForConfirmation if the
+// transaction failed
+type TransactionFailedError struct {
+	Transaction ITransaction
+}
+
+func (e *TransactionFailedError) Error() string {
+	return fmt.Sprintf("transaction %v failed", e.Transaction.L2TxnHash)
+}
+
+// WaitForConfirmation polls the transaction with l2Hash until it is
+// confirmed or failed, and returns it. Works for payouts and deposits
+//
+// Right after a payout AkashicScan may not have indexed the transaction
+// yet, which is waited out like a pending transaction. Transient errors are
+// retried. Returns *TransactionFailedError if the transaction failed and
+// *ConfirmationTimeoutError if the wait ends before it is final
+func (ap *AkashicPay) WaitForConfirmation(ctx context.Context, l2Hash string, opts WaitOptions) (transaction ITransaction, err error) {
+	ctx, end := ap.startOperation(ctx, "WaitForConfirmation")
+	defer func() { end(err) }()
+
+	if l2Hash == "" {
+		return ITransaction{}, errors.New("l2Hash may not be zero-valued")
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	interval := opts.InitialInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	maxInterval := opts.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = 30 * time.Second
+	}
+	multiplier := opts.Multiplier
+	if multiplier < 1 {
+		multiplier = 1.5
+	}
+
+	var last ITransaction
+	for {
+		tx, err := getTransactionDetails(ctx, ap.requester, ap.akashicUrl, l2Hash)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return ITransaction{}, &ConfirmationTimeoutError{L2Hash: l2Hash, Transaction: last, Err: ctx.Err()}
+		case err != nil && !transactionNotIndexed(err) && !isRetryableError(ctx, err):
+			return ITransaction{}, err
+		case err != nil:
+			ap.logger.DebugContext(ctx, "transaction not available yet", "l2Hash", l2Hash, "error", err)
+		case tx.Status == "":
+			// AkashicScan answers with an empty transaction until it has
+			// indexed it
+			ap.logger.DebugContext(ctx, "transaction not indexed yet", "l2Hash", l2Hash)
+		case tx.Status == CONFIRMED:
+			return tx, nil
+		case tx.Status == FAILED:
+			return tx, &TransactionFailedError{Transaction: tx}
+		default:
+			last = tx
+			ap.logger.DebugContext(ctx, "transaction pending", "l2Hash", l2Hash, "status", tx.Status)
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ITransaction{}, &ConfirmationTimeoutError{L2Hash: l2Hash, Transaction: last, Err: ctx.Err()}
+		case <-timer.C:
+		}
+		interval = min(time.Duration(float64(interval)*multiplier), maxInterval)
+	}
+}
+
+// transactionNotIndexed reports whether err means AkashicScan doesn't know
+// the transaction yet
+func transactionNotIndexed(err error) bool {
+	var httpErr *HTTPError
+	return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound
+}
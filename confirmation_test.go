@@ -0,0 +1,149 @@
+package akashicpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testL2Hash = "ASaaaa"
+
+// confirmationTestPoll is one answer of AkashicScan to a transaction lookup
+type confirmationTestPoll struct {
+	status int               // HTTP status, 200 if zero
+	tx     TransactionStatus // Status of the transaction, "" if not indexed
+}
+
+// newConfirmationTestPay returns an instance whose lookups of testL2Hash are
+// answered with polls in order, repeating the last one
+func newConfirmationTestPay(t *testing.T, polls []confirmationTestPoll) (*AkashicPay, *atomic.Int32) {
+	t.Helper()
+	var count atomic.Int32
+	serverUrl := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("l2Hash"); r.URL.Path != transactionsDetailsEndpoint || got != testL2Hash {
+			t.Errorf("unexpected request %v", r.URL)
+		}
+		poll := polls[min(int(count.Add(1)), len(polls))-1]
+		if poll.status != 0 {
+			w.WriteHeader(poll.status)
+			return
+		}
+		response := l2HashTransactionResponse{}
+		if poll.tx != "" {
+			response.Transaction = ITransaction{L2TxnHash: testL2Hash, Status: poll.tx}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	return newTestPay(t, serverUrl, WithoutBpCheck()), &count
+}
+
+func TestWaitForConfirmation(t *testing.T) {
+	tests := []struct {
+		name      string
+		polls     []confirmationTestPoll
+		wantPolls int32
+		wantTx    TransactionStatus
+		wantError func(err error) bool
+	}{
+		{
+			name:      "confirmed",
+			polls:     []confirmationTestPoll{{tx: CONFIRMED}},
+			wantPolls: 1,
+			wantTx:    CONFIRMED,
+		},
+		{
+			// Not indexed, answered either way, then pending
+			name:      "confirmed after waiting",
+			polls:     []confirmationTestPoll{{status: http.StatusNotFound}, {}, {tx: PENDING}, {tx: CONFIRMED}},
+			wantPolls: 4,
+			wantTx:    CONFIRMED,
+		},
+		{
+			name:      "transient error",
+			polls:     []confirmationTestPoll{{status: http.StatusServiceUnavailable}, {tx: CONFIRMED}},
+			wantPolls: 2,
+			wantTx:    CONFIRMED,
+		},
+		{
+			name:      "failed",
+			polls:     []confirmationTestPoll{{tx: PENDING}, {tx: FAILED}},
+			wantPolls: 2,
+			wantTx:    FAILED,
+			wantError: func(err error) bool {
+				var failed *TransactionFailedError
+				return errors.As(err, &failed) && failed.Transaction.Status == FAILED
+			},
+		},
+		{
+			name:      "permanent error",
+			polls:     []confirmationTestPoll{{status: http.StatusBadRequest}, {tx: CONFIRMED}},
+			wantPolls: 1,
+			wantError: func(err error) bool {
+				var httpErr *HTTPError
+				return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusBadRequest
+			},
+		},
+		{
+			name:  "still pending",
+			polls: []confirmationTestPoll{{tx: PENDING}},
+			wantError: func(err error) bool {
+				var timeout *ConfirmationTimeoutError
+				return errors.As(err, &timeout) && timeout.Transaction.Status == PENDING &&
+					errors.Is(err, context.DeadlineExceeded)
+			},
+		},
+		{
+			name:  "never indexed",
+			polls: []confirmationTestPoll{{status: http.StatusNotFound}},
+			wantError: func(err error) bool {
+				var timeout *ConfirmationTimeoutError
+				return errors.As(err, &timeout) && timeout.Transaction.Status == "" &&
+					strings.Contains(err.Error(), "not indexed")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ap, polls := newConfirmationTestPay(t, tt.polls)
+
+			tx, err := ap.WaitForConfirmation(context.Background(), testL2Hash, WaitOptions{
+				InitialInterval: time.Millisecond,
+				MaxInterval:     5 * time.Millisecond,
+				Timeout:         200 * time.Millisecond,
+			})
+			if tt.wantError == nil && err != nil {
+				t.Fatalf("WaitForConfirmation() = %v, want nil", err)
+			}
+			if tt.wantError != nil && !tt.wantError(err) {
+				t.Fatalf("WaitForConfirmation() = %v, want a different error", err)
+			}
+			if tx.Status != tt.wantTx {
+				t.Errorf("status = %q, want %q", tx.Status, tt.wantTx)
+			}
+			if got := polls.Load(); tt.wantPolls != 0 && got != tt.wantPolls {
+				t.Errorf("polled %d times, want %d", got, tt.wantPolls)
+			}
+		})
+	}
+}
+
+func TestWaitForConfirmationCancel(t *testing.T) {
+	ap, _ := newConfirmationTestPay(t, []confirmationTestPoll{{tx: PENDING}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := ap.WaitForConfirmation(ctx, testL2Hash, WaitOptions{InitialInterval: time.Hour})
+	var timeout *ConfirmationTimeoutError
+	if !errors.As(err, &timeout) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("WaitForConfirmation() = %v, want %v", err, context.Canceled)
+	}
+
+	if _, err := ap.WaitForConfirmation(context.Background(), "", WaitOptions{}); err == nil {
+		t.Error("WaitForConfirmation() without l2Hash = nil, want an error")
+	}
+}
@@ -0,0 +1,126 @@
+package akashicpay
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// EnvironmentConfig describes the AkashicChain deployment and the services
+// of an Environment
+type EnvironmentConfig struct {
+	Contracts        Contracts // Contracts of the AkashicChain deployment
+	DbIndex          int       // Database index transactions are written to
+	AkashicUrl       string    // AkashicScan API base URL
+	AkashicPayUrl    string    // AkashicPay base URL, used to build deposit URLs
+	AkashicPayApiUrl string    // AkashicPay API base URL
+	ACNodes          []string  // URLs of the AC nodes, health-checked before use
+	// Whether L1 mainnets (ETH, TRX, ...) are used, as opposed to testnets
+	// (SEP, TRX-SHASTA, ...)
+	Mainnet bool
+
+	nodes []acNode // Named nodes of the built-in environments
+}
+
+var environments = struct {
+	sync.RWMutex
+	configs map[Environment]EnvironmentConfig
+}{
+	configs: map[Environment]EnvironmentConfig{
+		Development: {
+			Contracts:        acTestNetContracts,
+			DbIndex:          15,
+			AkashicUrl:       akashicBaseUrlDev,
+			AkashicPayUrl:    akashicPayBaseUrlDev,
+			AkashicPayApiUrl: akashicPayApiBaseUrlDev,
+			nodes:            namedNodes(acDevNodes),
+		},
+		Production: {
+			Contracts:        acMainNetContracts,
+			DbIndex:          0,
+			AkashicUrl:       akashicBaseUrl,
+			AkashicPayUrl:    akashicPayBaseUrl,
+			AkashicPayApiUrl: akashicPayApiBaseUrl,
+			Mainnet:          true,
+			nodes:            namedNodes(acNodes),
+		},
+	},
+}
+
+// RegisterEnvironment makes env available to NewAkashicPay and
+// WithEnvironment, or replaces its configuration. The built-in Production
+// and Development environments can't be replaced. Use it to run the SDK
+// against a private AkashicChain deployment, e.g. the Local environment:
+//
+//	akashicpay.RegisterEnvironment(akashicpay.Local, akashicpay.EnvironmentConfig{
+//		Contracts:  akashicpay.Contracts{Create: "...", ...},
+//		DbIndex:    15,
+//		AkashicUrl: "http://localhost:8080/api",
+//		ACNodes:    []string{"http://localhost:5260/"},
+//	})
+//
+// Only instances created afterwards use the new configuration. To configure
+// a single instance, use WithEnvironmentConfig instead
+func RegisterEnvironment(env Environment, config EnvironmentConfig) error {
+	if env == "" {
+		return fmt.Errorf("environment may not be zero-valued")
+	}
+	if env == Production || env == Development {
+		return fmt.Errorf("built-in environment %v can't be replaced", env)
+	}
+	if len(config.ACNodes) == 0 {
+		return fmt.Errorf("environment %v needs at least one AC node", env)
+	}
+	config.ACNodes = slices.Clone(config.ACNodes)
+	config.nodes = nil
+
+	environments.Lock()
+	defer environments.Unlock()
+	environments.configs[env] = config
+	return nil
+}
+
+// environmentConfig returns the configuration set with WithEnvironmentConfig,
+// or else the one registered for the environment
+func (o *options) environmentConfig() (EnvironmentConfig, error) {
+	if o.envConfig == nil {
+		return environmentConfig(o.env)
+	}
+	config := *o.envConfig
+	config.nodes = nil
+	if len(config.ACNodes) == 0 && o.acNode == nil {
+		return EnvironmentConfig{}, fmt.Errorf("environment config needs at least one AC node")
+	}
+	return config, nil
+}
+
+// environmentConfig returns the configuration registered for env
+func environmentConfig(env Environment) (EnvironmentConfig, error) {
+	environments.RLock()
+	defer environments.RUnlock()
+	config, ok := environments.configs[env]
+	if !ok {
+		return EnvironmentConfig{}, fmt.Errorf("environment %v is not registered", env)
+	}
+	return config, nil
+}
+
+func (c EnvironmentConfig) urls() sdkUrls {
+	return sdkUrls{
+		AkashicUrl:       c.AkashicUrl,
+		AkashicPayUrl:    c.AkashicPayUrl,
+		AkashicPayApiUrl: c.AkashicPayApiUrl,
+	}
+}
+
+// acNodes returns the AC nodes of the environment
+func (c EnvironmentConfig) acNodes() []acNode {
+	if len(c.nodes) > 0 {
+		return c.nodes
+	}
+	nodes := make([]acNode, len(c.ACNodes))
+	for i, url := range c.ACNodes {
+		nodes[i] = acNode{Name: url, Node: withTrailingSlash(url)}
+	}
+	return nodes
+}
@@ -0,0 +1,44 @@
+package akashicpay
+
+import "testing"
+
+func TestRegisterEnvironment(t *testing.T) {
+	config := EnvironmentConfig{AkashicUrl: "http://localhost:8080/api", ACNodes: []string{"http://localhost:5260"}}
+	for _, env := range []Environment{Production, Development} {
+		if err := RegisterEnvironment(env, config); err == nil {
+			t.Errorf("RegisterEnvironment(%v) = nil, want an error", env)
+		}
+		if got, _ := environmentConfig(env); got.AkashicUrl == config.AkashicUrl {
+			t.Errorf("RegisterEnvironment(%v) replaced the built-in environment", env)
+		}
+	}
+	if err := RegisterEnvironment("Staging", EnvironmentConfig{}); err == nil {
+		t.Error("RegisterEnvironment() = nil without AC nodes, want an error")
+	}
+}
+
+func TestWithEnvironmentConfig(t *testing.T) {
+	config := EnvironmentConfig{
+		DbIndex:    15,
+		AkashicUrl: "http://localhost:8080/api",
+		ACNodes:    []string{"http://localhost:5260"},
+	}
+	ap, err := NewAkashicPayWithOptions(testPrivateKey, testIdentity, WithEnvironmentConfig(config), WithLazyConnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ap.Env != Local || ap.akashicUrl != config.AkashicUrl || ap.envConfig.DbIndex != config.DbIndex {
+		t.Errorf("instance uses %v at %v, want the given config", ap.Env, ap.akashicUrl)
+	}
+	if nodes := ap.nodes.nodes; len(nodes) != 1 || nodes[0].Node != "http://localhost:5260/" {
+		t.Errorf("nodes = %+v, want the node of the config", nodes)
+	}
+
+	// The config is not registered for other instances
+	if _, err := environmentConfig(Local); err == nil {
+		t.Error("WithEnvironmentConfig registered the Local environment")
+	}
+	if _, err := NewAkashicPayWithOptions(testPrivateKey, testIdentity, WithEnvironmentConfig(EnvironmentConfig{}), WithLazyConnect()); err == nil {
+		t.Error("NewAkashicPayWithOptions() = nil without AC nodes, want an error")
+	}
+}
@@ -6,9 +6,11 @@ require (
 	github.com/activeledger/SDK-Golang v0.0.0-20210406173904-a1540fa01452
 	github.com/btcsuite/btcd/btcec/v2 v2.3.5
 	github.com/titanous/bitcoin-crypto v0.0.0-20121127183713-5eeb3a67e50a
+	golang.org/x/crypto v0.45.0
 )
 
 require (
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
 	github.com/peterhellberg/sseclient v0.0.0-20190910165922-d1094337c01e // indirect
+	golang.org/x/sys v0.38.0 // indirect
 )
@@ -2,105 +2,223 @@ package akashicpay
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"slices"
+	"syscall"
+	"time"
 )
 
 const (
 	// TODO: Get version from somewhere
 	version = "1.0.0"
 	client  = "go-sdk"
+
+	// Error bodies beyond this size are truncated
+	maxErrorBodySize = 1 << 20
 )
 
 var defaultClient = &http.Client{}
 
-// TODO: Handle bad response statuses (400s etc.)
-func get[T any](url string) (T, error) {
-	var result T
-
-	request, err := http.NewRequest("GET", url, nil)
+// requester performs the HTTP calls of a single AkashicPay instance
+type requester struct {
+	client          Doer // The HTTP client wrapped in the interceptors
+	retry           RetryPolicy
+	logger          *slog.Logger
+	instrumentation Instrumentation
+	limiters        []*rateLimiter
+	breakers        *circuitBreakers // nil if disabled
+}
 
-	if err != nil {
-		return result, err
+func newRequester(o *options) *requester {
+	r := &requester{
+		client:          chainInterceptors(o.httpClient, o.interceptors),
+		retry:           o.retryPolicy,
+		logger:          o.logger,
+		instrumentation: o.instrumentation,
+	}
+	if o.circuitBreaker != nil {
+		r.breakers = newCircuitBreakers(*o.circuitBreaker)
 	}
+	return r
+}
 
-	setHeaders(request)
+// Send a GET request. Error statuses are returned as *HTTPError. Retried
+// according to the RetryPolicy
+func get[T any](ctx context.Context, r *requester, url string) (T, error) {
+	var result T
 
-	response, err := defaultClient.Do(request)
+	body, err := r.send(ctx, "GET", url, nil, true)
 
 	if err != nil {
 		return result, err
 	}
-	err = checkResponseForErrors(response)
 
+	err = json.Unmarshal(body, &result)
 	if err != nil {
 		return result, err
 	}
+	return result, nil
+}
 
-	defer response.Body.Close()
-	body, err := io.ReadAll(response.Body)
+// Send a POST request. data should be a struct with json tags. Only retried
+// if the RetryPolicy allows retrying non-idempotent requests
+func post[T any](ctx context.Context, r *requester, url string, data any) (T, error) {
+	return sendJson[T](ctx, r, url, data, r.retry.RetryNonIdempotent)
+}
+
+// Send a POST request that is never retried. Used for transactions sent to
+// AkashicChain, where a retry could execute the transaction twice
+func postOnce[T any](ctx context.Context, r *requester, url string, data any) (T, error) {
+	return sendJson[T](ctx, r, url, data, false)
+}
+
+func sendJson[T any](ctx context.Context, r *requester, url string, data any, retryable bool) (T, error) {
+	var result T
+
+	jsonData, err := json.Marshal(data)
 
 	if err != nil {
 		return result, err
 	}
 
-	err = json.Unmarshal(body, &result)
+	body, err := r.send(ctx, "POST", url, jsonData, retryable)
+
 	if err != nil {
 		return result, err
 	}
+
+	// Unmarshal freaks out if body is empty
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &result)
+		if err != nil {
+			return result, err
+		}
+	}
 	return result, nil
 }
 
-// Send a POST request. data should be a struct with json tags
-func post[T any](url string, data any) (T, error) {
-	var result T
+// send performs the request, retrying it according to the RetryPolicy if
+// retryable is set, and returns the response body
+func (r *requester) send(ctx context.Context, method string, url string, data []byte, retryable bool) ([]byte, error) {
+	attempts := 1
+	if retryable {
+		attempts = r.retry.attempts()
+	}
 
-	jsonData, err := json.Marshal(data)
+	for attempt := 1; ; attempt++ {
+		body, err := r.sendOnce(ctx, method, url, data)
+		if err == nil || attempt >= attempts || !isRetryableError(ctx, err) {
+			return body, err
+		}
 
-	if err != nil {
-		return result, err
+		backoff, ok := r.retry.backoff(attempt, err)
+		if !ok {
+			r.logger.WarnContext(ctx, "not retrying request, Retry-After too long",
+				"method", method,
+				"endpoint", endpointForLog(url),
+				"retryAfter", backoff,
+				"error", err,
+			)
+			return body, err
+		}
+		r.logger.WarnContext(ctx, "retrying request",
+			"method", method,
+			"endpoint", endpointForLog(url),
+			"attempt", attempt,
+			"backoff", backoff,
+			"error", err,
+		)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *requester) sendOnce(ctx context.Context, method string, url string, data []byte) (body []byte, err error) {
+	if r.breakers != nil {
+		breaker := r.breakers.forEndpoint(method + " " + endpointForLog(url))
+		ticket, err := breaker.allow()
+		if err != nil {
+			return nil, err
+		}
+		defer func() { breaker.done(ticket, countsAsCircuitFailure(ctx, err)) }()
 	}
 
-	request, err := http.NewRequest("POST", url, bytes.NewReader(jsonData))
-	request.ContentLength = int64(len(jsonData))
+	if err := r.waitForRateLimit(ctx, url); err != nil {
+		return nil, err
+	}
+
+	var requestBody io.Reader
+	if data != nil {
+		requestBody = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, requestBody)
 
 	if err != nil {
-		return result, err
+		return nil, err
 	}
 
 	setHeaders(request)
 
-	response, err := defaultClient.Do(request)
+	start := time.Now()
+	response, err := r.client.Do(request)
 
 	if err != nil {
-		return result, err
+		latency := time.Since(start)
+		r.logger.WarnContext(ctx, "http request failed",
+			"method", method,
+			"endpoint", endpointForLog(url),
+			"latency", latency,
+			"error", err,
+		)
+		r.instrumentation.HTTPRequest(ctx, HTTPRequestInfo{
+			Method:   method,
+			Endpoint: endpointForLog(url),
+			Duration: latency,
+			Err:      err,
+		})
+		return nil, err
 	}
+	defer response.Body.Close()
 
 	err = checkResponseForErrors(response)
 
+	latency := time.Since(start)
+	level := slog.LevelDebug
 	if err != nil {
-		return result, err
+		level = slog.LevelWarn
 	}
-
-	defer response.Body.Close()
-	body, err := io.ReadAll(response.Body)
+	r.logger.Log(ctx, level, "http request",
+		"method", method,
+		"endpoint", endpointForLog(url),
+		"status", response.StatusCode,
+		"latency", latency,
+	)
+	r.instrumentation.HTTPRequest(ctx, HTTPRequestInfo{
+		Method:     method,
+		Endpoint:   endpointForLog(url),
+		StatusCode: response.StatusCode,
+		Duration:   latency,
+		Err:        err,
+	})
 
 	if err != nil {
-		return result, err
+		return nil, err
 	}
 
-	// Unmarshal freaks out if body is empty
-	if len(body) > 0 {
-		err = json.Unmarshal(body, &result)
-		if err != nil {
-			return result, err
-		}
-	}
-	return result, nil
+	return io.ReadAll(response.Body)
 }
 
 func setHeaders(request *http.Request) {
@@ -109,32 +227,57 @@ func setHeaders(request *http.Request) {
 	request.Header.Set("Ap-Client", client)
 }
 
-// Checks for HTTP errors, handles and formats them
+// Checks for HTTP errors and returns them as *HTTPError. Does not close the
+// body
 func checkResponseForErrors(response *http.Response) error {
 	if response.StatusCode < 400 {
 		return nil
 	}
-	// Check if response has a JSON body we can parse for an error message
-	isJson := slices.ContainsFunc(response.Header.Values("Content-Type"), func(e string) bool { m, err := regexp.MatchString("application/json", e); return m && err == nil })
+	httpErr := &HTTPError{
+		StatusCode: response.StatusCode,
+		RequestId:  requestIdFromHeader(response.Header),
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+	}
+	if response.Request != nil {
+		endpoint := *response.Request.URL
+		endpoint.RawQuery = ""
+		httpErr.Method = response.Request.Method
+		httpErr.Endpoint = endpoint.String()
+	}
 
-	if isJson {
-		var httpError map[string]any
-		body, err := io.ReadAll(response.Body)
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxErrorBodySize))
+	if err != nil {
+		return err
+	}
+	httpErr.Body = body
 
-		if err != nil {
-			return err
-		}
+	// Check if response has a JSON body we can parse for an error message
+	isJson := slices.ContainsFunc(response.Header.Values("Content-Type"), func(e string) bool { m, err := regexp.MatchString("application/json", e); return m && err == nil })
 
-		// Unmarshal freaks out if body is empty
-		if len(body) > 0 {
-			err = json.Unmarshal(body, &httpError)
-			if err == nil {
-				return fmt.Errorf("%v: %v", httpError["error"], httpError["message"])
-			}
+	// Unmarshal freaks out if body is empty
+	if isJson && len(body) > 0 {
+		var jsonError map[string]any
+		if err := json.Unmarshal(body, &jsonError); err == nil {
+			httpErr.ErrorCode = jsonErrorField(jsonError["error"])
+			httpErr.Message = jsonErrorField(jsonError["message"])
 		}
 	}
 
-	// If it's not JSON or we couldn't find any JSON body, just return status code
-	return fmt.Errorf("HTTP Error: %v", response.StatusCode)
+	return httpErr
+}
+
+func jsonErrorField(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
 
+// isConnectionRefused reports whether err was caused by the server refusing
+// the connection, i.e. the request never reached it
+func isConnectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
 }
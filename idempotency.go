@@ -0,0 +1,384 @@
+package akashicpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// How long after its transaction expired a pending payout that isn't found
+// on AkashicScan may be sent again. Gives AkashicScan time to index it
+const pendingPayoutGrace = 1 * time.Minute
+
+// Page size and most pages findPayout looks through
+const (
+	findPayoutPageSize = 100
+	findPayoutMaxPages = 100
+)
+
+// IdempotencyStore records payouts by referenceId, so a payout repeated with
+// the same referenceId, e.g. after a crash, doesn't send money twice.
+// Implementations must be safe for concurrent use
+type IdempotencyStore interface {
+	// Get returns the record of referenceId, if there is one
+	Get(ctx context.Context, referenceId string) (PayoutRecord, bool, error)
+	// Put creates or replaces the record of record.ReferenceId
+	Put(ctx context.Context, record PayoutRecord) error
+	// Delete removes the record of referenceId, if there is one
+	Delete(ctx context.Context, referenceId string) error
+}
+
+// PayoutRecordState is the state of a payout in an IdempotencyStore
+type PayoutRecordState string
+
+const (
+	// The payout was started and may have been submitted
+	PayoutRecordPending PayoutRecordState = "Pending"
+	// The payout was accepted by AkashicChain
+	PayoutRecordCompleted PayoutRecordState = "Completed"
+)
+
+// PayoutRecord is what an IdempotencyStore keeps of a payout
+type PayoutRecord struct {
+	ReferenceId string
+	State       PayoutRecordState
+	To          string
+	Amount      string
+	Network     NetworkSymbol
+	Token       TokenSymbol
+	// When the signed transaction expires. Zero if the payout was never
+	// signed, and so never submitted
+	ExpiresAt time.Time
+	// What the payout policies counted for the signed transaction. Released
+	// if the payout is sent again because that transaction went nowhere
+	Policy    PolicyPayout
+	Result    PayoutResult // Set once the payout is completed
+	CreatedAt time.Time
+}
+
+// WithIdempotencyStore makes payouts idempotent by referenceId. The intent
+// to pay is recorded in store before the transaction is signed. Repeating a
+// completed payout returns its original result. Repeating one whose outcome
+// is unknown checks GetTransfers for the referenceId first, and only sends
+// it again once the previous transaction provably expired without being
+// accepted
+//
+// Concurrent payouts with the same referenceId are serialized within the
+// instance only. Share a store between processes only if they never pay out
+// the same referenceId at the same time
+func WithIdempotencyStore(store IdempotencyStore) Option {
+	return func(o *options) {
+		o.idempotencyStore = store
+	}
+}
+
+// idempotency guards the payouts of an instance with its IdempotencyStore
+type idempotency struct {
+	store IdempotencyStore
+
+	mu    sync.Mutex
+	locks map[string]*referenceLock
+}
+
+type referenceLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newIdempotency(store IdempotencyStore) *idempotency {
+	return &idempotency{store: store, locks: make(map[string]*referenceLock)}
+}
+
+// lock blocks until no other payout with referenceId is in progress. The
+// returned function releases the lock
+func (i *idempotency) lock(referenceId string) func() {
+	i.mu.Lock()
+	l, ok := i.locks[referenceId]
+	if !ok {
+		l = &referenceLock{}
+		i.locks[referenceId] = l
+	}
+	l.refs++
+	i.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		i.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(i.locks, referenceId)
+		}
+		i.mu.Unlock()
+	}
+}
+
+// matches reports whether record is for the same payment as req
+func (record PayoutRecord) matches(req PayoutRequest) bool {
+	return record.To == req.To &&
+		record.Amount == req.Amount &&
+		record.Network == req.Network &&
+		record.Token == req.Token
+}
+
+// idempotentPayout makes the payout of req with send unless it was already
+// made. send must call beforeSubmit with the signed payout before
+// submitting it
+func (ap *AkashicPay) idempotentPayout(ctx context.Context, req PayoutRequest, send func(beforeSubmit func(payout signedPayout) error) (PayoutResult, error)) (PayoutResult, error) {
+	store := ap.idempotency.store
+	unlock := ap.idempotency.lock(req.ReferenceId)
+	defer unlock()
+
+	record, found, err := store.Get(ctx, req.ReferenceId)
+	if err != nil {
+		return PayoutResult{}, err
+	}
+	if found {
+		if !record.matches(req) {
+			return PayoutResult{}, newAkashicError(AkashicErrorCodeIdempotencyConflict, "")
+		}
+		if record.State == PayoutRecordCompleted {
+			ap.logger.InfoContext(ctx, "payout already completed", "referenceId", req.ReferenceId, "l2Hash", record.Result.L2Hash)
+			return record.Result, nil
+		}
+
+		// A previous attempt may have reached AkashicChain
+		transfer, found, err := ap.findPayout(ctx, req.ReferenceId)
+		if err != nil {
+			return PayoutResult{}, err
+		}
+		if found {
+			record.State = PayoutRecordCompleted
+			record.Result = payoutResultFromTransfer(transfer)
+			ap.logger.InfoContext(ctx, "payout already submitted", "referenceId", req.ReferenceId, "l2Hash", record.Result.L2Hash)
+			return record.Result, store.Put(ctx, record)
+		}
+		// A failed transfer doesn't mean the last attempt failed, it may be
+		// an earlier one. So wait for the last transaction to expire
+		if !record.ExpiresAt.IsZero() && time.Now().Before(record.ExpiresAt.Add(pendingPayoutGrace)) {
+			return PayoutResult{}, newAkashicError(AkashicErrorCodePayoutPending, "")
+		}
+		// The previous transaction went nowhere, so it no longer counts
+		// towards the policies
+		if record.Policy.Id != "" {
+			if err := releasePolicies(ctx, ap.payoutPolicies, record.Policy); err != nil {
+				return PayoutResult{}, err
+			}
+		}
+	}
+
+	record = PayoutRecord{
+		ReferenceId: req.ReferenceId,
+		State:       PayoutRecordPending,
+		To:          req.To,
+		Amount:      req.Amount,
+		Network:     req.Network,
+		Token:       req.Token,
+		CreatedAt:   time.Now(),
+	}
+	if err := store.Put(ctx, record); err != nil {
+		return PayoutResult{}, err
+	}
+
+	signed := false
+	result, err := send(func(payout signedPayout) error {
+		expiresAt, err := time.Parse(time.RFC3339, payout.Tx.TxObject.Expire)
+		if err != nil {
+			return fmt.Errorf("invalid transaction expiry %q: %w", payout.Tx.TxObject.Expire, err)
+		}
+		record.ExpiresAt = expiresAt
+		record.Policy = payout.Policy
+		signed = true
+		return store.Put(ctx, record)
+	})
+	if err != nil {
+		if !signed {
+			// Nothing was sent, so the payout may simply be made again
+			return PayoutResult{}, errors.Join(err, store.Delete(ctx, req.ReferenceId))
+		}
+		return PayoutResult{}, err
+	}
+
+	record.State = PayoutRecordCompleted
+	record.Result = result
+	if err := store.Put(ctx, record); err != nil {
+		// The money was sent, so report success. A repeated payout will find
+		// the transfer on AkashicScan
+		ap.logger.ErrorContext(ctx, "failed to record completed payout", "referenceId", req.ReferenceId, "error", err)
+	}
+	return result, nil
+}
+
+// findPayout looks for a payout with referenceId on AkashicScan that is
+// pending or confirmed. Failed ones are ignored
+func (ap *AkashicPay) findPayout(ctx context.Context, referenceId string) (ITransaction, bool, error) {
+	// The first page is requested without a page number, then pages from 1
+	// on. That covers all transfers whether pages are counted from 0 or 1
+	for page := 0; page < findPayoutMaxPages; page++ {
+		transfers, err := ap.GetTransfersContext(ctx, IGetTransactions{
+			Page:            page,
+			Limit:           findPayoutPageSize,
+			TransactionType: WITHDRAWAL,
+			ReferenceId:     referenceId,
+		})
+		if err != nil {
+			return ITransaction{}, false, err
+		}
+		for _, transfer := range transfers {
+			if transfer.ReferenceId != referenceId || transfer.L2TxnHash == "" || transfer.Status == FAILED {
+				continue
+			}
+			return transfer, true, nil
+		}
+		if len(transfers) < findPayoutPageSize {
+			return ITransaction{}, false, nil
+		}
+	}
+	return ITransaction{}, false, fmt.Errorf("more than %v pages of transfers with referenceId %v", findPayoutMaxPages, referenceId)
+}
+
+// payoutResultFromTransfer describes a payout found on AkashicScan. How it
+// was submitted is unknown
+func payoutResultFromTransfer(transfer ITransaction) PayoutResult {
+	result := PayoutResult{
+		L2Hash: transfer.L2TxnHash,
+		Route:  PayoutRouteL1,
+	}
+	if transfer.Layer == L2 {
+		result.Route = PayoutRouteL2
+		result.L2Address = transfer.ToAddress
+	} else {
+		result.FromAddress = transfer.FromAddress
+	}
+	return result
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore. Records are lost
+// when the process exits, so it only protects against repeated calls
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]PayoutRecord
+}
+
+// NewMemoryIdempotencyStore returns an empty MemoryIdempotencyStore
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]PayoutRecord)}
+}
+
+func (s *MemoryIdempotencyStore) Get(ctx context.Context, referenceId string) (PayoutRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[referenceId]
+	return record, ok, nil
+}
+
+func (s *MemoryIdempotencyStore) Put(ctx context.Context, record PayoutRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.ReferenceId] = record
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Delete(ctx context.Context, referenceId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, referenceId)
+	return nil
+}
+
+// FileIdempotencyStore is an IdempotencyStore that keeps all records in a
+// single JSON file, which survives crashes. Every change rewrites the file,
+// so it suits a moderate number of records
+type FileIdempotencyStore struct {
+	path string
+
+	mu      sync.Mutex
+	records map[string]PayoutRecord
+}
+
+// NewFileIdempotencyStore returns a store backed by the file at path,
+// loading the records already in it. The file is created on the first change
+func NewFileIdempotencyStore(path string) (*FileIdempotencyStore, error) {
+	s := &FileIdempotencyStore{path: path, records: make(map[string]PayoutRecord)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.records); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *FileIdempotencyStore) Get(ctx context.Context, referenceId string) (PayoutRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[referenceId]
+	return record, ok, nil
+}
+
+func (s *FileIdempotencyStore) Put(ctx context.Context, record PayoutRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.records[record.ReferenceId]
+	s.records[record.ReferenceId] = record
+	if err := s.save(); err != nil {
+		if existed {
+			s.records[record.ReferenceId] = previous
+		} else {
+			delete(s.records, record.ReferenceId)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileIdempotencyStore) Delete(ctx context.Context, referenceId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.records[referenceId]
+	if !existed {
+		return nil
+	}
+	delete(s.records, referenceId)
+	if err := s.save(); err != nil {
+		s.records[referenceId] = previous
+		return err
+	}
+	return nil
+}
+
+// save atomically replaces the file with the current records
+func (s *FileIdempotencyStore) save() error {
+	data, err := json.Marshal(s.records)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,306 @@
+package akashicpay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newIdempotencyTestPay returns an instance whose GetTransfers answers with
+// transfers
+func newIdempotencyTestPay(t *testing.T, store IdempotencyStore, transfers []ITransaction) *AkashicPay {
+	t.Helper()
+	serverUrl := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(transactionsResponse{Transactions: transfers})
+	}))
+	return newTestPay(t, serverUrl, WithoutBpCheck(), WithIdempotencyStore(store))
+}
+
+func TestIdempotentPayout(t *testing.T) {
+	req := PayoutRequest{ReferenceId: "ref-1", To: "TAddress", Amount: "1.5", Network: Tron_Shasta, Token: USDT}
+	pending := func(expiresAt time.Time) *PayoutRecord {
+		return &PayoutRecord{
+			ReferenceId: req.ReferenceId,
+			State:       PayoutRecordPending,
+			To:          req.To,
+			Amount:      req.Amount,
+			Network:     req.Network,
+			Token:       req.Token,
+			ExpiresAt:   expiresAt,
+		}
+	}
+	completed := pending(time.Now())
+	completed.State = PayoutRecordCompleted
+	completed.Result = PayoutResult{L2Hash: "ASold"}
+	conflicting := pending(time.Time{})
+	conflicting.Amount = "2"
+	transfer := func(status TransactionStatus) ITransaction {
+		return ITransaction{ReferenceId: req.ReferenceId, L2TxnHash: "ASfound", Status: status, Layer: L1}
+	}
+
+	tests := []struct {
+		name      string
+		record    *PayoutRecord
+		transfers []ITransaction
+		wantSent  bool
+		wantHash  string
+		wantCode  AkashicErrorCode
+	}{
+		{name: "new", wantSent: true, wantHash: "ASnew"},
+		{name: "completed", record: completed, wantHash: "ASold"},
+		{name: "conflict", record: conflicting, wantCode: AkashicErrorCodeIdempotencyConflict},
+		{name: "pending and found", record: pending(time.Now().Add(time.Hour)), transfers: []ITransaction{transfer(PENDING)}, wantHash: "ASfound"},
+		{name: "pending and confirmed", record: pending(time.Now().Add(-time.Hour)), transfers: []ITransaction{transfer(CONFIRMED)}, wantHash: "ASfound"},
+		// The failed transfer may be of an earlier attempt than the pending one
+		{name: "pending and failed", record: pending(time.Now().Add(time.Hour)), transfers: []ITransaction{transfer(FAILED)}, wantCode: AkashicErrorCodePayoutPending},
+		{name: "failed and expired", record: pending(time.Now().Add(-2 * pendingPayoutGrace)), transfers: []ITransaction{transfer(FAILED)}, wantSent: true, wantHash: "ASnew"},
+		{name: "pending and not expired", record: pending(time.Now().Add(time.Hour)), wantCode: AkashicErrorCodePayoutPending},
+		{name: "pending within grace", record: pending(time.Now().Add(-pendingPayoutGrace / 2)), wantCode: AkashicErrorCodePayoutPending},
+		{name: "pending and expired", record: pending(time.Now().Add(-2 * pendingPayoutGrace)), wantSent: true, wantHash: "ASnew"},
+		{name: "pending but never signed", record: pending(time.Time{}), wantSent: true, wantHash: "ASnew"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := NewMemoryIdempotencyStore()
+			if tt.record != nil {
+				store.Put(ctx, *tt.record)
+			}
+			ap := newIdempotencyTestPay(t, store, tt.transfers)
+
+			sent := false
+			result, err := ap.idempotentPayout(ctx, req, func(beforeSubmit func(payout signedPayout) error) (PayoutResult, error) {
+				sent = true
+				var payout signedPayout
+				addExpireToTx(&payout.Tx, time.Minute)
+				if err := beforeSubmit(payout); err != nil {
+					return PayoutResult{}, err
+				}
+				return PayoutResult{L2Hash: "ASnew"}, nil
+			})
+
+			if sent != tt.wantSent {
+				t.Errorf("sent = %v, want %v", sent, tt.wantSent)
+			}
+			if tt.wantCode != "" {
+				var akashicErr *AkashicError
+				if !errors.As(err, &akashicErr) || akashicErr.Code != tt.wantCode {
+					t.Fatalf("err = %v, want %v", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if result.L2Hash != tt.wantHash {
+				t.Errorf("L2Hash = %v, want %v", result.L2Hash, tt.wantHash)
+			}
+			record, found, _ := store.Get(ctx, req.ReferenceId)
+			if !found || record.State != PayoutRecordCompleted || record.Result.L2Hash != tt.wantHash {
+				t.Errorf("record = %+v, found %v, want completed with %v", record, found, tt.wantHash)
+			}
+		})
+	}
+}
+
+func TestIdempotentPayoutFailure(t *testing.T) {
+	req := PayoutRequest{ReferenceId: "ref-1", To: "TAddress", Amount: "1.5", Network: Tron_Shasta}
+	sendErr := errors.New("send failed")
+
+	tests := []struct {
+		name        string
+		signed      bool
+		wantPending bool
+	}{
+		// Nothing was sent, so the record is removed
+		{name: "before signing", signed: false, wantPending: false},
+		// The transaction may have been sent, so the record stays
+		{name: "after signing", signed: true, wantPending: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := NewMemoryIdempotencyStore()
+			ap := newIdempotencyTestPay(t, store, nil)
+
+			_, err := ap.idempotentPayout(ctx, req, func(beforeSubmit func(payout signedPayout) error) (PayoutResult, error) {
+				if tt.signed {
+					var payout signedPayout
+					addExpireToTx(&payout.Tx, time.Minute)
+					if err := beforeSubmit(payout); err != nil {
+						return PayoutResult{}, err
+					}
+				}
+				return PayoutResult{}, sendErr
+			})
+			if !errors.Is(err, sendErr) {
+				t.Fatalf("err = %v, want %v", err, sendErr)
+			}
+			record, found, _ := store.Get(ctx, req.ReferenceId)
+			if found != tt.wantPending {
+				t.Fatalf("record found = %v, want %v", found, tt.wantPending)
+			}
+			if found && (record.State != PayoutRecordPending || record.ExpiresAt.IsZero()) {
+				t.Errorf("record = %+v, want pending with expiry", record)
+			}
+		})
+	}
+}
+
+func TestFindPayoutPages(t *testing.T) {
+	const referenceId = "ref 1&status=Confirmed"
+	var pages []string
+	serverUrl := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if got := query.Get("referenceId"); got != referenceId {
+			t.Errorf("referenceId = %q, want %q", got, referenceId)
+		}
+		if got := query.Get("limit"); got != "100" {
+			t.Errorf("limit = %q, want 100", got)
+		}
+		page := query.Get("page")
+		pages = append(pages, page)
+
+		// Two full pages of failed transfers, then the pending one
+		transfers := make([]ITransaction, findPayoutPageSize)
+		for i := range transfers {
+			transfers[i] = ITransaction{ReferenceId: referenceId, L2TxnHash: "ASfailed", Status: FAILED}
+		}
+		if page == "2" {
+			transfers = []ITransaction{{ReferenceId: referenceId, L2TxnHash: "ASfound", Status: PENDING}}
+		}
+		json.NewEncoder(w).Encode(transactionsResponse{Transactions: transfers})
+	}))
+	ap := newTestPay(t, serverUrl, WithoutBpCheck())
+
+	transfer, found, err := ap.findPayout(context.Background(), referenceId)
+	if err != nil || !found || transfer.L2TxnHash != "ASfound" {
+		t.Fatalf("findPayout() = %+v, %v, %v, want ASfound", transfer, found, err)
+	}
+	if want := []string{"", "1", "2"}; !slices.Equal(pages, want) {
+		t.Errorf("pages = %q, want %q", pages, want)
+	}
+}
+
+func TestIdempotentPayoutReleasesEarlierCount(t *testing.T) {
+	req := PayoutRequest{ReferenceId: "ref-1", To: testTronAddress, Amount: "60", Network: Tron}
+	tests := []struct {
+		name         string
+		expiresAt    time.Time
+		wantReleased bool
+	}{
+		// The earlier transaction may still go through
+		{name: "not expired", expiresAt: time.Now().Add(time.Hour), wantReleased: false},
+		{name: "expired", expiresAt: time.Now().Add(-2 * pendingPayoutGrace), wantReleased: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			policy, err := NewLimitsPolicy(LimitsPolicyConfig{
+				Limits: map[PolicyAsset]PayoutLimits{{Network: Tron}: {MaxPer24h: "100"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			earlier := newTestPolicyPayout(t, req.ReferenceId, req.To, req.Amount)
+			if err := policy.Evaluate(ctx, earlier); err != nil {
+				t.Fatal(err)
+			}
+			store := NewMemoryIdempotencyStore()
+			store.Put(ctx, PayoutRecord{
+				ReferenceId: req.ReferenceId,
+				State:       PayoutRecordPending,
+				To:          req.To,
+				Amount:      req.Amount,
+				Network:     req.Network,
+				ExpiresAt:   tt.expiresAt,
+				Policy:      earlier,
+			})
+			ap := newIdempotencyTestPay(t, store, nil)
+			ap.payoutPolicies = []PayoutPolicy{policy}
+
+			ap.idempotentPayout(ctx, req, func(beforeSubmit func(payout signedPayout) error) (PayoutResult, error) {
+				return PayoutResult{}, errors.New("not sent")
+			})
+			total, err := policy.counters.Sum(ctx, policyCounterKey(PolicyAsset{Network: Tron}), time.Now().Add(-policyWindow))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if released := total.Sign() == 0; released != tt.wantReleased {
+				t.Errorf("earlier count released = %v, want %v", released, tt.wantReleased)
+			}
+		})
+	}
+}
+
+func TestApplyPayoutOptionsSetsExpiry(t *testing.T) {
+	var tx acTransaction
+	if err := applyPayoutOptions(&tx, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339, tx.TxObject.Expire); err != nil {
+		t.Fatalf("Expire = %q, want a default expiry", tx.TxObject.Expire)
+	}
+
+	tx.TxObject.Expire = "2030-01-01T00:00:00Z"
+	if err := applyPayoutOptions(&tx, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if tx.TxObject.Expire != "2030-01-01T00:00:00Z" {
+		t.Errorf("Expire = %q, want the expiry set by AkashicScan", tx.TxObject.Expire)
+	}
+}
+
+func TestApplyPayoutOptionsKeepsSignedTransaction(t *testing.T) {
+	signed := func() acTransaction {
+		return acTransaction{Signature: map[string]any{"AS1": "signature"}}
+	}
+
+	tx := signed()
+	if err := applyPayoutOptions(&tx, nil, 0); err != nil || tx.TxObject.Expire != "" {
+		t.Errorf("applyPayoutOptions() = %v, Expire %q, want the transaction unchanged", err, tx.TxObject.Expire)
+	}
+	tx = signed()
+	if err := applyPayoutOptions(&tx, map[string]any{"orderId": "1"}, 0); err == nil || tx.TxObject.Metadata != nil {
+		t.Errorf("applyPayoutOptions() with metadata = %v, want an error and the transaction unchanged", err)
+	}
+	tx = signed()
+	if err := applyPayoutOptions(&tx, nil, time.Hour); err == nil || tx.TxObject.Expire != "" {
+		t.Errorf("applyPayoutOptions() with expiry = %v, want an error and the transaction unchanged", err)
+	}
+}
+
+func TestFileIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "payouts.json")
+	store, err := NewFileIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := PayoutRecord{ReferenceId: "ref-1", State: PayoutRecordPending, Amount: "1"}
+	if err := store.Put(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, PayoutRecord{ReferenceId: "ref-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "ref-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, found, _ := reopened.Get(ctx, "ref-1"); !found || got.State != record.State || got.Amount != record.Amount {
+		t.Errorf("Get(ref-1) = %+v, %v, want %+v", got, found, record)
+	}
+	if _, found, _ := reopened.Get(ctx, "ref-2"); found {
+		t.Error("Get(ref-2) found a deleted record")
+	}
+}
@@ -0,0 +1,162 @@
+package akashicpay
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Instrumentation receives events from an AkashicPay instance, e.g. to
+// create tracing spans or record metrics without the SDK depending on a
+// tracing library. Implementations must be safe for concurrent use
+type Instrumentation interface {
+	// OperationStart is called when a public method starts. The returned
+	// context is used for the rest of the operation, e.g. to carry a span
+	OperationStart(ctx context.Context, operation string) context.Context
+	// OperationEnd is called with the context returned by OperationStart
+	// when the operation returns
+	OperationEnd(ctx context.Context, operation string, err error)
+	// HTTPRequest is called after every HTTP request, including each retry
+	HTTPRequest(ctx context.Context, info HTTPRequestInfo)
+	// ChainSubmit is called after every transaction sent to AkashicChain
+	ChainSubmit(ctx context.Context, info ChainSubmitInfo)
+}
+
+// HTTPRequestInfo describes a finished HTTP request
+type HTTPRequestInfo struct {
+	Method     string
+	Endpoint   string // URL of the request, without query
+	StatusCode int    // 0 if no response was received
+	Duration   time.Duration
+	Err        error
+}
+
+// ChainSubmitInfo describes a transaction sent to AkashicChain
+type ChainSubmitInfo struct {
+	Node     string         // URL the transaction was sent to
+	Path     SubmissionPath // SubmitDirect or SubmitMinigate
+	Contract string
+	Umid     string // Empty if the node did not accept the transaction
+	Commit   int    // Number of nodes that committed the transaction
+	Vote     int    // Number of nodes that voted for the transaction
+	Total    int    // Number of nodes that took part in consensus
+	Duration time.Duration
+	Err      error
+}
+
+// WithInstrumentation sets the Instrumentation the instance reports
+// operations, HTTP requests and AkashicChain submissions to
+func WithInstrumentation(instrumentation Instrumentation) Option {
+	return func(o *options) {
+		if instrumentation != nil {
+			o.instrumentation = instrumentation
+		}
+	}
+}
+
+// NoopInstrumentation ignores all events. It is the default
+type NoopInstrumentation struct{}
+
+func (NoopInstrumentation) OperationStart(ctx context.Context, operation string) context.Context {
+	return ctx
+}
+func (NoopInstrumentation) OperationEnd(ctx context.Context, operation string, err error) {}
+func (NoopInstrumentation) HTTPRequest(ctx context.Context, info HTTPRequestInfo)         {}
+func (NoopInstrumentation) ChainSubmit(ctx context.Context, info ChainSubmitInfo)         {}
+
+// RecordedOperation is an operation seen by RecordingInstrumentation
+type RecordedOperation struct {
+	Name     string
+	Start    time.Time
+	Duration time.Duration // Zero while the operation is running
+	Ended    bool
+	Err      error
+}
+
+// RecordingInstrumentation records all events in memory, for use in tests
+type RecordingInstrumentation struct {
+	mu           sync.Mutex
+	operations   []*RecordedOperation
+	httpRequests []HTTPRequestInfo
+	chainSubmits []ChainSubmitInfo
+}
+
+type recordedOperationKey struct{}
+
+func (r *RecordingInstrumentation) OperationStart(ctx context.Context, operation string) context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recorded := &RecordedOperation{Name: operation, Start: time.Now()}
+	r.operations = append(r.operations, recorded)
+	return context.WithValue(ctx, recordedOperationKey{}, recorded)
+}
+
+func (r *RecordingInstrumentation) OperationEnd(ctx context.Context, operation string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Operations started before a Reset are no longer listed, so updating
+	// them has no effect
+	recorded, ok := ctx.Value(recordedOperationKey{}).(*RecordedOperation)
+	if !ok {
+		return
+	}
+	recorded.Duration = time.Since(recorded.Start)
+	recorded.Ended = true
+	recorded.Err = err
+}
+
+func (r *RecordingInstrumentation) HTTPRequest(ctx context.Context, info HTTPRequestInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.httpRequests = append(r.httpRequests, info)
+}
+
+func (r *RecordingInstrumentation) ChainSubmit(ctx context.Context, info ChainSubmitInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chainSubmits = append(r.chainSubmits, info)
+}
+
+// Operations returns the recorded operations in the order they started
+func (r *RecordingInstrumentation) Operations() []RecordedOperation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	operations := make([]RecordedOperation, len(r.operations))
+	for i, recorded := range r.operations {
+		operations[i] = *recorded
+	}
+	return operations
+}
+
+// HTTPRequests returns the recorded HTTP requests in the order they finished
+func (r *RecordingInstrumentation) HTTPRequests() []HTTPRequestInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.httpRequests)
+}
+
+// ChainSubmits returns the recorded AkashicChain submissions
+func (r *RecordingInstrumentation) ChainSubmits() []ChainSubmitInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.chainSubmits)
+}
+
+// Reset discards everything recorded so far
+func (r *RecordingInstrumentation) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.operations = nil
+	r.httpRequests = nil
+	r.chainSubmits = nil
+}
+
+// startOperation reports the start of a public method and returns the
+// context to use for it, and a function to call with its result
+func (ap *AkashicPay) startOperation(ctx context.Context, operation string) (context.Context, func(error)) {
+	ctx = ap.instrumentation.OperationStart(ctx, operation)
+	return ctx, func(err error) {
+		ap.instrumentation.OperationEnd(ctx, operation, err)
+	}
+}
@@ -0,0 +1,29 @@
+package akashicpay
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRecordingInstrumentationReset(t *testing.T) {
+	r := &RecordingInstrumentation{}
+	errFailed := errors.New("failed")
+
+	before := r.OperationStart(context.Background(), "Before")
+	r.Reset()
+	during := r.OperationStart(context.Background(), "During")
+
+	// Ending the operation started before the Reset must not end the one
+	// recorded after it
+	r.OperationEnd(before, "Before", errFailed)
+	if operations := r.Operations(); len(operations) != 1 || operations[0].Ended {
+		t.Fatalf("Operations() = %+v, want During still running", operations)
+	}
+
+	r.OperationEnd(during, "During", nil)
+	operations := r.Operations()
+	if len(operations) != 1 || operations[0].Name != "During" || !operations[0].Ended || operations[0].Err != nil {
+		t.Fatalf("Operations() = %+v, want During ended without error", operations)
+	}
+}
@@ -0,0 +1,72 @@
+package akashicpay
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Doer sends an HTTP request. *http.Client implements it
+type Doer interface {
+	Do(request *http.Request) (*http.Response, error)
+}
+
+// DoerFunc adapts a function to a Doer
+type DoerFunc func(request *http.Request) (*http.Response, error)
+
+func (f DoerFunc) Do(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// Interceptor wraps the Doer that sends a request, e.g. to add headers,
+// audit requests and responses or inject faults in tests:
+//
+//	func correlationId(next akashicpay.Doer) akashicpay.Doer {
+//		return akashicpay.DoerFunc(func(req *http.Request) (*http.Response, error) {
+//			req.Header.Set("X-Correlation-Id", newId())
+//			return next.Do(req)
+//		})
+//	}
+//
+// The SDK closes the body of every response it receives, including error
+// responses. An interceptor that replaces a response must close the body of
+// the one it discards
+type Interceptor func(next Doer) Doer
+
+// WithInterceptors adds interceptors to every request the instance makes,
+// including AC node health checks. The first interceptor is the outermost,
+// i.e. it sees the request first and the response last
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// chainInterceptors wraps client in interceptors
+func chainInterceptors(client Doer, interceptors []Interceptor) Doer {
+	doer := client
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		doer = interceptors[i](doer)
+	}
+	return closingDoer{doer}
+}
+
+// closingDoer makes sure no response body is leaked when a Doer returns both
+// a response and an error, and that a response without an error has a body
+type closingDoer struct {
+	next Doer
+}
+
+func (d closingDoer) Do(request *http.Request) (*http.Response, error) {
+	response, err := d.next.Do(request)
+	if err != nil && response != nil && response.Body != nil {
+		response.Body.Close()
+		response = nil
+	}
+	if err == nil && response == nil {
+		return nil, errors.New("interceptor returned neither a response nor an error")
+	}
+	if err == nil && response.Body == nil {
+		response.Body = http.NoBody
+	}
+	return response, err
+}
@@ -0,0 +1,34 @@
+package akashicpay
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestInterceptorWithoutResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *http.Response
+		wantErr  bool
+	}{
+		{name: "no response", response: nil, wantErr: true},
+		{name: "response without body", response: &http.Response{StatusCode: http.StatusOK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := defaultOptions()
+			o.interceptors = []Interceptor{func(next Doer) Doer {
+				return DoerFunc(func(request *http.Request) (*http.Response, error) {
+					return tt.response, nil
+				})
+			}}
+			r := newRequester(&o)
+
+			_, err := r.sendOnce(context.Background(), "GET", "http://localhost/test", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sendOnce() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package akashicpay
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+)
+
+// Replaces secrets in log output
+const redacted = "[REDACTED]"
+
+// WithLogger sets the logger the instance reports HTTP calls, AkashicChain
+// submissions and AC node selection to. Private keys and signatures are
+// never logged. By default nothing is logged
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// LogValue implements slog.LogValuer, redacting the signatures
+func (tx acTransaction) LogValue() slog.Value {
+	sigs := make(map[string]string, len(tx.Signature))
+	for identity := range tx.Signature {
+		sigs[identity] = redacted
+	}
+	return slog.GroupValue(
+		slog.String("namespace", tx.TxObject.Namespace),
+		slog.String("contract", tx.TxObject.Contract),
+		slog.String("entry", tx.TxObject.Entry),
+		slog.String("expire", tx.TxObject.Expire),
+		slog.Any("metadata", tx.TxObject.Metadata),
+		slog.Any("$sigs", sigs),
+	)
+}
+
+// LogValue implements slog.LogValuer, redacting the private key
+func (otk Otk) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("identity", otk.Identity),
+		slog.String("publicKey", otk.publicKey),
+		slog.String("privateKey", redacted),
+	)
+}
+
+// endpointForLog strips the query from rawUrl, which may contain addresses
+// or identifiers of users
+func endpointForLog(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+// payoutStepFailed logs which step of a payout failed and returns err
+func (ap *AkashicPay) payoutStepFailed(ctx context.Context, step string, referenceId string, err error) error {
+	ap.logger.ErrorContext(ctx, "payout failed",
+		"step", step,
+		"referenceId", referenceId,
+		"node", ap.CurrentACNode(),
+		"error", err,
+	)
+	return err
+}
@@ -0,0 +1,353 @@
+package akashicpay
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AC node status reported by a/status when the node is healthy
+const acNodeHealthyStatus = 4
+
+// How long node discovery waits for the nodes to answer by default
+const defaultNodeProbeTimeout = 5 * time.Second
+
+// NodeStat is the last known state of an AkashicChain node
+type NodeStat struct {
+	Name        string        // Region name of the node, e.g. "Singapore1"
+	Url         string        // URL transactions are sent to
+	Healthy     bool          // Whether the node is currently ranked as healthy
+	Current     bool          // Whether transactions are currently sent to this node
+	Latency     time.Duration // Response time of the last health check
+	LastStatus  int           // Status reported by the last health check, 4 is healthy
+	LastError   error         // Error of the last health check or transaction, if any
+	LastChecked time.Time     // Time of the last health check
+}
+
+// nodePool keeps the AC nodes ranked by health and hands out the node
+// transactions are sent to. Safe for concurrent use
+type nodePool struct {
+	r            *requester
+	nodes        []acNode      // All candidate nodes
+	probeTimeout time.Duration // Upper bound for one round of health checks
+
+	mu         sync.RWMutex
+	ranked     []acNode // Healthy nodes, fastest first
+	generation uint64   // Incremented whenever ranked changes
+	stats      map[acNode]NodeStat
+
+	refreshMu sync.Mutex // Serializes re-probing
+
+	stopMonitor chan struct{}
+	monitorDone chan struct{}
+}
+
+type nodeProbeResult struct {
+	Node    acNode
+	Status  int
+	Latency time.Duration
+	Error   error
+}
+
+// newNodePool returns a pool of nodes that has no healthy node until it is
+// connected
+func newNodePool(r *requester, nodes []acNode, probeTimeout time.Duration) *nodePool {
+	p := newStaticNodePool(r, nodes, probeTimeout)
+	p.ranked = nil
+	return p
+}
+
+// newStaticNodePool uses nodes as they are, without probing them first
+func newStaticNodePool(r *requester, nodes []acNode, probeTimeout time.Duration) *nodePool {
+	if probeTimeout <= 0 {
+		probeTimeout = defaultNodeProbeTimeout
+	}
+	stats := make(map[acNode]NodeStat, len(nodes))
+	for _, node := range nodes {
+		stats[node] = NodeStat{Name: node.Name, Url: node.Node}
+	}
+	return &nodePool{
+		r:            r,
+		nodes:        nodes,
+		probeTimeout: probeTimeout,
+		ranked:       slices.Clone(nodes),
+		stats:        stats,
+	}
+}
+
+// namedNodes returns nodes sorted by name, with their name set
+func namedNodes(nodes map[string]acNode) []acNode {
+	result := make([]acNode, 0, len(nodes))
+	for name, node := range nodes {
+		node.Name = name
+		result = append(result, node)
+	}
+	slices.SortFunc(result, func(a, b acNode) int { return strings.Compare(a.Name, b.Name) })
+	return result
+}
+
+// current returns the best healthy node and the generation of the ranking it
+// was taken from
+func (p *nodePool) current() (acNode, uint64) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.ranked) == 0 {
+		return acNode{}, p.generation
+	}
+	return p.ranked[0], p.generation
+}
+
+// connect probes the nodes and ranks the healthy ones, unless there already
+// is a healthy node. Returns an error if none of them is healthy
+func (p *nodePool) connect(ctx context.Context) error {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+	if node, _ := p.current(); node.Node != "" {
+		return nil
+	}
+	return p.refresh(ctx)
+}
+
+// refresh probes all nodes and replaces the ranking with the healthy ones,
+// fastest first. The ranking is left as is if no node is healthy, and the
+// returned error lists why each node failed
+func (p *nodePool) refresh(ctx context.Context) error {
+	probeCtx, cancel := context.WithTimeout(ctx, p.probeTimeout)
+	defer cancel()
+	results := probeNodes(probeCtx, p.r, p.nodes)
+
+	var healthy []nodeProbeResult
+	var errs []error
+	for _, res := range results {
+		if res.Error == nil && res.Status != acNodeHealthyStatus {
+			res.Error = fmt.Errorf("unhealthy status %d", res.Status)
+		}
+		if res.Error != nil {
+			errs = append(errs, fmt.Errorf("%v (%v): %w", res.Node.Name, res.Node.Node, res.Error))
+			continue
+		}
+		healthy = append(healthy, res)
+	}
+	slices.SortStableFunc(healthy, func(a, b nodeProbeResult) int { return cmp.Compare(a.Latency, b.Latency) })
+
+	ranked := make([]acNode, len(healthy))
+	for i, res := range healthy {
+		ranked[i] = res.Node
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	for _, res := range results {
+		stat := p.stats[res.Node]
+		stat.Latency = res.Latency
+		stat.LastStatus = res.Status
+		stat.LastError = res.Error
+		stat.LastChecked = now
+		p.stats[res.Node] = stat
+	}
+	if len(ranked) > 0 {
+		p.ranked = ranked
+		p.generation++
+	}
+	p.mu.Unlock()
+
+	for _, res := range results {
+		p.r.logger.DebugContext(ctx, "probed AC node",
+			"node", res.Node.Name,
+			"url", res.Node.Node,
+			"status", res.Status,
+			"latency", res.Latency,
+			"error", res.Error,
+		)
+	}
+	if len(ranked) > 0 {
+		p.r.logger.InfoContext(ctx, "selected AC node",
+			"node", ranked[0].Name,
+			"url", ranked[0].Node,
+			"healthy", len(ranked),
+			"total", len(p.nodes),
+		)
+	}
+
+	if len(ranked) == 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("no healthy AC node: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// reportError records err as the last error of node
+func (p *nodePool) reportError(node acNode, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if stat, ok := p.stats[node]; ok {
+		stat.LastError = err
+		p.stats[node] = stat
+	}
+}
+
+// snapshot returns the stats of all nodes, in the order of p.nodes
+func (p *nodePool) snapshot() []NodeStat {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	stats := make([]NodeStat, len(p.nodes))
+	for i, node := range p.nodes {
+		stat := p.stats[node]
+		stat.Healthy = slices.Contains(p.ranked, node)
+		stat.Current = len(p.ranked) > 0 && p.ranked[0] == node
+		stats[i] = stat
+	}
+	return stats
+}
+
+// failover is called after failed could not be reached. Unless another
+// caller already re-ranked the nodes since generation, all nodes are probed
+// again and failed is moved to the back of the ranking. Returns the node to
+// use next and the generation of the new ranking
+func (p *nodePool) failover(ctx context.Context, failed acNode, generation uint64) (acNode, uint64, error) {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	if node, gen := p.current(); gen != generation {
+		return node, gen, nil
+	}
+
+	p.r.logger.WarnContext(ctx, "AC node unavailable, re-probing nodes",
+		"node", failed.Name,
+		"url", failed.Node,
+	)
+	if err := p.refresh(ctx); err != nil {
+		return acNode{}, 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.ranked) > 1 && p.ranked[0] == failed {
+		p.ranked = append(p.ranked[1:], failed)
+	}
+	return p.ranked[0], p.generation, nil
+}
+
+// startMonitor re-probes the nodes every interval until close is called
+func (p *nodePool) startMonitor(interval time.Duration) {
+	p.stopMonitor = make(chan struct{})
+	p.monitorDone = make(chan struct{})
+	go func() {
+		defer close(p.monitorDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stopMonitor:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				p.refreshMu.Lock()
+				// A failed refresh keeps the last known ranking
+				_ = p.refresh(ctx)
+				p.refreshMu.Unlock()
+				cancel()
+			}
+		}
+	}()
+}
+
+func (p *nodePool) close() {
+	if p.stopMonitor == nil {
+		return
+	}
+	select {
+	case <-p.stopMonitor:
+	default:
+		close(p.stopMonitor)
+	}
+	<-p.monitorDone
+}
+
+// probeNodes concurrently checks the health of all nodes. Results are in
+// the order the nodes answered
+func probeNodes(ctx context.Context, r *requester, nodes []acNode) []nodeProbeResult {
+	// Use a goroutine to concurrently check node health
+	results := make(chan nodeProbeResult, len(nodes))
+	for _, node := range nodes {
+		go func(node acNode) {
+			start := time.Now()
+			status, err := probeNode(ctx, r, node)
+			results <- nodeProbeResult{node, status, time.Since(start), err}
+		}(node)
+	}
+
+	probed := make([]nodeProbeResult, 0, len(nodes))
+	for range nodes {
+		probed = append(probed, <-results)
+	}
+	return probed
+}
+
+func probeNode(ctx context.Context, r *requester, node acNode) (int, error) {
+	type AcResponse struct {
+		Status int `json:"status"`
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", node.Node+"a/status", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	var nodeData AcResponse
+	err = json.Unmarshal(body, &nodeData)
+	if err != nil {
+		return 0, err
+	}
+	return nodeData.Status, nil
+}
+
+// neverReachedNode reports whether err proves the request was never
+// received by the node, i.e. the connection could not be established. Only
+// then is it safe to send a transaction to a different node
+func neverReachedNode(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return isConnectionRefused(err) || isCircuitOpen(err)
+}
+
+// nodeUnavailable reports whether err indicates the node itself is
+// unhealthy, as opposed to the transaction being rejected
+func nodeUnavailable(err error) bool {
+	if isCircuitOpen(err) {
+		return true
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || neverReachedNode(err)
+}
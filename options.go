@@ -0,0 +1,168 @@
+package akashicpay
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Option configures an AkashicPay instance created with
+// NewAkashicPayWithOptions
+type Option func(*options)
+
+type options struct {
+	env                 Environment
+	envConfig           *EnvironmentConfig
+	apiSecret           string
+	httpClient          *http.Client
+	akashicUrl          string
+	akashicPayUrl       string
+	akashicPayApiUrl    string
+	acNode              *acNode
+	skipBpCheck         bool
+	retryPolicy         RetryPolicy
+	nodeMonitorInterval time.Duration
+	nodeProbeTimeout    time.Duration
+	logger              *slog.Logger
+	instrumentation     Instrumentation
+	rateLimits          map[RateLimitTarget]RateLimit
+	cacheTTL            CacheTTL
+	cacheStore          Cache
+	lazyConnect         bool
+	submissionPath      SubmissionPath
+	interceptors        []Interceptor
+	circuitBreaker      *CircuitBreakerConfig
+	idempotencyStore    IdempotencyStore
+	balanceCheck        bool
+	payoutPolicies      []PayoutPolicy
+	txExpiry            time.Duration
+}
+
+func defaultOptions() options {
+	return options{
+		env:             Development,
+		httpClient:      defaultClient,
+		logger:          slog.New(slog.DiscardHandler),
+		instrumentation: NoopInstrumentation{},
+	}
+}
+
+// WithEnvironment sets the environment of the instance. Defaults to
+// Development
+func WithEnvironment(env Environment) Option {
+	return func(o *options) {
+		o.env = env
+		o.envConfig = nil
+	}
+}
+
+// WithEnvironmentConfig runs the instance against a private AkashicChain
+// deployment described by config. Unlike RegisterEnvironment it only
+// affects this instance. Its Env is Local
+func WithEnvironmentConfig(config EnvironmentConfig) Option {
+	return func(o *options) {
+		config.ACNodes = slices.Clone(config.ACNodes)
+		o.env = Local
+		o.envConfig = &config
+	}
+}
+
+// WithApiSecret sets the API-secret used by VerifySignature
+func WithApiSecret(apiSecret string) Option {
+	return func(o *options) {
+		o.apiSecret = apiSecret
+	}
+}
+
+// WithHTTPClient sets the *http.Client used for every request the instance
+// makes, including AC node health checks. A nil client is ignored
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		if client != nil {
+			o.httpClient = client
+		}
+	}
+}
+
+// WithAkashicScanUrl overrides the AkashicScan API base URL of the
+// environment, e.g. "http://localhost:8080/api"
+func WithAkashicScanUrl(baseUrl string) Option {
+	return func(o *options) {
+		o.akashicUrl = strings.TrimRight(baseUrl, "/")
+	}
+}
+
+// WithAkashicPayUrl overrides the AkashicPay base URL of the environment,
+// used to build deposit URLs
+func WithAkashicPayUrl(baseUrl string) Option {
+	return func(o *options) {
+		o.akashicPayUrl = strings.TrimRight(baseUrl, "/")
+	}
+}
+
+// WithAkashicPayApiUrl overrides the AkashicPay API base URL of the
+// environment
+func WithAkashicPayApiUrl(baseUrl string) Option {
+	return func(o *options) {
+		o.akashicPayApiUrl = strings.TrimRight(baseUrl, "/")
+	}
+}
+
+// WithACNode sets the AkashicChain node transactions are sent to, skipping
+// the health check of the public nodes. minigateUrl can be left out ("")
+func WithACNode(nodeUrl string, minigateUrl string) Option {
+	return func(o *options) {
+		o.acNode = &acNode{
+			Name:     "Custom",
+			Node:     withTrailingSlash(nodeUrl),
+			Minigate: withTrailingSlash(minigateUrl),
+		}
+	}
+}
+
+// WithNodeHealthMonitor re-probes the AC nodes every interval in the
+// background, so a degraded node is replaced before a transaction fails on
+// it. Call Close to stop the monitor
+func WithNodeHealthMonitor(interval time.Duration) Option {
+	return func(o *options) {
+		o.nodeMonitorInterval = interval
+	}
+}
+
+// WithNodeProbeTimeout bounds how long a round of AC node health checks may
+// take. Nodes that have not answered by then count as unhealthy. Defaults to
+// 5 seconds
+func WithNodeProbeTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.nodeProbeTimeout = timeout
+	}
+}
+
+// WithLazyConnect defers AC node selection and the AkashicPay sign-up check
+// until the first call that needs them, or an explicit call to Connect. The
+// private key is still validated during initialization. Useful if the
+// instance is mostly used for calls like VerifySignature that don't need a
+// connection, or to not fail at boot because of a network blip
+func WithLazyConnect() Option {
+	return func(o *options) {
+		o.lazyConnect = true
+	}
+}
+
+// WithoutBpCheck skips checking that the identity is signed up on
+// AkashicPay. The instance is then treated as a regular (non-FX) business
+// partner
+func WithoutBpCheck() Option {
+	return func(o *options) {
+		o.skipBpCheck = true
+	}
+}
+
+func withTrailingSlash(u string) string {
+	if u == "" || strings.HasSuffix(u, "/") {
+		return u
+	}
+	return u + "/"
+}
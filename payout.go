@@ -0,0 +1,300 @@
+package akashicpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+)
+
+// FeeDelegationStrategy decides how the network fee of an L1 payout is paid
+type FeeDelegationStrategy string
+
+const (
+	// The fee is paid from the BP's own L1 funds
+	FeeDelegationNone FeeDelegationStrategy = "None"
+	// The fee is paid by AkashicPay and deducted from the BP's balance. The
+	// default
+	FeeDelegationDelegate FeeDelegationStrategy = "Delegate"
+)
+
+// Metadata keys set by the SDK, which PayoutRequest.Metadata may not use
+var reservedMetadataKeys = []string{"referenceId", "initiatedToNonL2"}
+
+// PayoutRequest describes a payout made with PayoutWithOptions
+type PayoutRequest struct {
+	ReferenceId string        // Your identifier of the payout
+	To          string        // L1 address, L2 address or alias of the receiver
+	Amount      string        // Amount in the main unit, e.g. "1.5"
+	Network     NetworkSymbol // Network to pay out on
+	Token       TokenSymbol   // Token to pay out, zero-valued for the native coin
+
+	// How the L1 network fee is paid. Ignored for L2 payouts. Defaults to
+	// FeeDelegationDelegate
+	FeeDelegationStrategy FeeDelegationStrategy
+	// How long the transaction is valid for. Defaults to the expiry set
+	// with WithTransactionExpiry, or one minute
+	Expiry time.Duration
+	// Free-form fields written into the metadata of the transaction. Values
+	// must be JSON-serializable, and "referenceId" and "initiatedToNonL2" are
+	// reserved
+	Metadata map[string]any
+}
+
+// validate checks the fields of req that don't need a request to verify
+func (req PayoutRequest) validate() error {
+	if req.ReferenceId == "" {
+		return errors.New("referenceId may not be zero-valued")
+	}
+	if req.To == "" {
+		return errors.New("to may not be zero-valued")
+	}
+	if req.Amount == "" {
+		return errors.New("amount may not be zero-valued")
+	}
+	if req.Network == "" {
+		return errors.New("network may not be zero-valued")
+	}
+	switch req.FeeDelegationStrategy {
+	case "", FeeDelegationNone, FeeDelegationDelegate:
+	default:
+		return fmt.Errorf("unknown fee delegation strategy %q", req.FeeDelegationStrategy)
+	}
+	if req.Expiry < 0 {
+		return errors.New("expiry may not be negative")
+	}
+	for _, key := range reservedMetadataKeys {
+		if _, ok := req.Metadata[key]; ok {
+			return fmt.Errorf("metadata key %q is reserved", key)
+		}
+	}
+	return validateDecimalPlaces(req.Amount, req.Network, req.Token)
+}
+
+func (req PayoutRequest) feeDelegation() FeeDelegationStrategy {
+	if req.FeeDelegationStrategy == "" {
+		return FeeDelegationDelegate
+	}
+	return req.FeeDelegationStrategy
+}
+
+// PayoutResult describes a completed payout
+type PayoutResult struct {
+	L2Hash        string // L2 hash of the transaction, prefixed with "AS"
+	Route         PayoutRoute
+	RecipientKind RecipientKind
+	// L2 address the payout was sent to. Empty for L1 payouts
+	L2Address string
+	// Fee delegated to AkashicPay. Only set for L1 payouts prepared by
+	// AkashicScan
+	DelegatedFee string
+	// L1 address the withdrawal is sent from. Only set for L1 payouts
+	// prepared by AkashicScan
+	FromAddress string
+
+	Node           string         // Name of the AC node the transaction was submitted to
+	NodeUrl        string         // URL of that AC node
+	SubmissionPath SubmissionPath // Whether the node or its minigate was used
+
+	// Consensus summary reported by the node
+	Commit int
+	Vote   int
+	Total  int
+}
+
+// PayoutWithOptions is like Payout, but allows choosing the fee delegation
+// strategy, the expiry of the transaction and additional metadata, and
+// describes how the payout was made
+func (ap *AkashicPay) PayoutWithOptions(ctx context.Context, req PayoutRequest) (result PayoutResult, err error) {
+	ctx, end := ap.startOperation(ctx, "Payout")
+	defer func() { end(err) }()
+
+	if err := req.validate(); err != nil {
+		return PayoutResult{}, err
+	}
+
+	// Whether the BP is an FX BP decides how the transaction is built
+	if err := ap.Connect(ctx); err != nil {
+		return PayoutResult{}, err
+	}
+
+	send := func(beforeSubmit func(payout signedPayout) error) (PayoutResult, error) {
+		return ap.payout(ctx, req, beforeSubmit)
+	}
+	if ap.idempotency != nil {
+		return ap.idempotentPayout(ctx, req, send)
+	}
+	return send(nil)
+}
+
+// payout signs and submits req. beforeSubmit, if set, is called with the
+// signed payout and aborts the payout if it fails
+func (ap *AkashicPay) payout(ctx context.Context, req PayoutRequest, beforeSubmit func(payout signedPayout) error) (PayoutResult, error) {
+	if ap.balanceCheck {
+		if err := ap.checkPayoutBalance(ctx, req); err != nil {
+			return PayoutResult{}, ap.payoutStepFailed(ctx, "balanceCheck", req.ReferenceId, err)
+		}
+	}
+	signed, err := ap.signPayout(ctx, req)
+	if err != nil {
+		return PayoutResult{}, err
+	}
+	return ap.sendSignedPayout(ctx, req.ReferenceId, signed, beforeSubmit)
+}
+
+// sendSignedPayout calls beforeSubmit, if set, and submits payout unless it
+// fails
+func (ap *AkashicPay) sendSignedPayout(ctx context.Context, referenceId string, payout signedPayout, beforeSubmit func(payout signedPayout) error) (PayoutResult, error) {
+	if beforeSubmit != nil {
+		if err := beforeSubmit(payout); err != nil {
+			return PayoutResult{}, errors.Join(err, releasePolicies(ctx, ap.payoutPolicies, payout.Policy))
+		}
+	}
+	return ap.submitSignedPayout(ctx, referenceId, payout)
+}
+
+// signedPayout is a payout that is signed and ready to be submitted
+type signedPayout struct {
+	Tx     acTransaction
+	Result PayoutResult // Without the fields only known after submission
+	Policy PolicyPayout // What the payout policies allowed
+}
+
+// signPayout resolves the receiver of req, checks it against the payout
+// policies and builds and signs the transaction
+func (ap *AkashicPay) signPayout(ctx context.Context, req PayoutRequest) (_ signedPayout, err error) {
+	referenceId := req.ReferenceId
+	network := req.Network
+	token := req.Token
+	expiry := req.Expiry
+	if expiry == 0 {
+		expiry = ap.txExpiry
+	}
+
+	recipient, err := ap.resolvePayout(ctx, req)
+	if err != nil {
+		return signedPayout{}, err
+	}
+	result := PayoutResult{
+		Route:         recipient.Route,
+		RecipientKind: recipient.Kind,
+	}
+
+	policy := newPolicyPayout(req, recipient)
+	if err := ap.evaluatePolicies(ctx, policy); err != nil {
+		return signedPayout{}, ap.payoutStepFailed(ctx, "policy", referenceId, err)
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, releasePolicies(ctx, ap.payoutPolicies, policy))
+		}
+	}()
+
+	if recipient.Route == PayoutRouteL2 {
+		result.L2Address = recipient.ToAddress
+
+		acToken := mapUSDTToTether(network, token)
+		signedTx, err := l2Transaction(ap.envConfig, ap.otk, network, recipient.Amount, recipient.ToAddress, acToken, recipient.InitiatedToNonL2, referenceId, ap.isFxBp, req.Metadata, expiry)
+		if err != nil {
+			return signedPayout{}, ap.payoutStepFailed(ctx, "sign", referenceId, err)
+		}
+
+		//If FX, double-sign on BE
+		if ap.isFxBp {
+			res, err := prepareL2Txn(ctx, ap.requester, ap.akashicUrl, prepareL2TxnDto{SignedTx: signedTx})
+			if err != nil {
+				return signedPayout{}, ap.payoutStepFailed(ctx, "prepareL2Txn", referenceId, err)
+			}
+			signedTx = res.PreparedTxn
+		}
+		return signedPayout{Tx: signedTx, Result: result, Policy: policy}, nil
+	}
+
+	Payload := prepareTxnDto{
+		ToAddress:             req.To,
+		Amount:                req.Amount,
+		NetworkSymbol:         network,
+		TokenSymbol:           token,
+		ReferenceId:           referenceId,
+		Identity:              ap.otk.Identity,
+		FeeDelegationStrategy: req.feeDelegation(),
+	}
+
+	res, err := prepareL1Txn(ctx, ap.requester, ap.akashicUrl, Payload)
+
+	PreparedTxn := res.PreparedTxn
+
+	if err != nil {
+		var akashicErr *AkashicError
+		if errors.As(err, &akashicErr) && akashicErr.Code == AkashicErrorCodeSavingsExceeded {
+			return signedPayout{}, ap.payoutStepFailed(ctx, "prepareL1Txn", referenceId, akashicErr)
+		} else if isConnectionRefused(err) {
+			ap.logger.WarnContext(ctx, "AkashicScan unreachable, building L1 transaction locally", "referenceId", referenceId)
+			PreparedTxn = l1Transaction(ap.envConfig, ap.otk.Identity, network, req.Amount, req.To, token, referenceId, req.feeDelegation(), req.Metadata, expiry)
+		} else {
+			return signedPayout{}, ap.payoutStepFailed(ctx, "prepareL1Txn", referenceId, err)
+		}
+	} else {
+		if err := applyPayoutOptions(&PreparedTxn, req.Metadata, expiry); err != nil {
+			return signedPayout{}, ap.payoutStepFailed(ctx, "prepareL1Txn", referenceId, err)
+		}
+		result.DelegatedFee = res.DelegatedFee
+		result.FromAddress = res.FromAddress
+	}
+
+	signedTx, err := signTransaction(PreparedTxn, ap.otk)
+	if err != nil {
+		return signedPayout{}, ap.payoutStepFailed(ctx, "sign", referenceId, err)
+	}
+	return signedPayout{Tx: signedTx, Result: result, Policy: policy}, nil
+}
+
+// submitSignedPayout submits the transaction of payout to AkashicChain
+func (ap *AkashicPay) submitSignedPayout(ctx context.Context, referenceId string, payout signedPayout) (PayoutResult, error) {
+	acRes, submission, err := submitTransaction[any](ctx, ap, payout.Tx)
+	if err != nil {
+		return PayoutResult{}, ap.payoutStepFailed(ctx, "submit", referenceId, err)
+	}
+	acErr := checkForAkashicChainError(acRes)
+	if acErr != nil {
+		return PayoutResult{}, ap.payoutStepFailed(ctx, "submit", referenceId, acErr)
+	}
+
+	result := payout.Result
+	result.L2Hash, err = prefixWithAS(acRes.Umid)
+	if err != nil {
+		return PayoutResult{}, err
+	}
+	result.Node = submission.Node.Name
+	result.NodeUrl = submission.Node.Node
+	result.SubmissionPath = submission.Path
+	result.Commit = acRes.Summary.Commit
+	result.Vote = acRes.Summary.Vote
+	result.Total = acRes.Summary.Total
+	return result, nil
+}
+
+// applyPayoutOptions adds metadata and the expiry to a transaction prepared
+// by AkashicScan. Metadata set by AkashicScan is kept, and so is its expiry
+// unless one is given. Without either the default expiry is used, so the
+// transaction always has one. A transaction AkashicScan already signed is
+// left as is, as changing it would break the signatures
+func applyPayoutOptions(tx *acTransaction, metadata map[string]any, expiry time.Duration) error {
+	if len(tx.Signature) > 0 {
+		if len(metadata) > 0 || expiry > 0 {
+			return errors.New("transaction prepared by AkashicScan is already signed, so metadata and expiry can't be added")
+		}
+		return nil
+	}
+	if len(metadata) > 0 {
+		merged := maps.Clone(metadata)
+		maps.Copy(merged, tx.TxObject.Metadata)
+		tx.TxObject.Metadata = merged
+	}
+	if expiry > 0 {
+		tx.TxObject.Expire = ""
+	}
+	addExpireToTx(tx, expiry)
+	return nil
+}
@@ -0,0 +1,511 @@
+package akashicpay
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Window of the rolling caps of LimitsPolicy
+const policyWindow = 24 * time.Hour
+
+// PayoutPolicy decides whether a payout may be made. Implementations must be
+// safe for concurrent use
+type PayoutPolicy interface {
+	// Evaluate is called once the receiver of a payout is resolved, before
+	// the transaction is signed. A non-nil error rejects the payout, usually
+	// a *PolicyViolation
+	Evaluate(ctx context.Context, payout PolicyPayout) error
+	// Release is called if a payout Evaluate allowed is then not sent after
+	// all, e.g. because another policy rejected it or signing failed. Payouts
+	// that may have reached AkashicChain are never released
+	Release(ctx context.Context, payout PolicyPayout) error
+}
+
+// BatchPayoutPolicy is a PayoutPolicy that can check a whole batch up
+// front, so PayoutBatch doesn't stop halfway through because of it
+type BatchPayoutPolicy interface {
+	PayoutPolicy
+	// CheckBatch checks payouts like Evaluate would if they were made one
+	// after the other, without counting them. Returns the violation of each
+	// payout, nil if it is allowed
+	CheckBatch(ctx context.Context, payouts []PolicyPayout) ([]error, error)
+}
+
+// RecheckPayoutPolicy is a PayoutPolicy that can check again, without
+// counting it, a payout it allowed before. SubmitPayout does so before
+// submitting a prepared payout
+type RecheckPayoutPolicy interface {
+	PayoutPolicy
+	// Recheck fails if payout, which Evaluate allowed, may no longer be made
+	Recheck(ctx context.Context, payout PolicyPayout) error
+}
+
+// PolicyPayout is the payout a PayoutPolicy evaluates
+type PolicyPayout struct {
+	// Identifies this evaluation of the payout. Release is called with the
+	// same Id. A payout repeated with the same referenceId is evaluated
+	// under a new Id, as the earlier attempt may still go through
+	Id            string
+	Request       PayoutRequest
+	Route         PayoutRoute
+	RecipientKind RecipientKind
+	// L2 address the payout is sent to. Empty for L1 payouts
+	L2Address string
+	// Amount in the smallest unit of the currency
+	SmallestUnitAmount string
+}
+
+// WithPayoutPolicy adds policies that every payout must pass. They are
+// evaluated in order. PayoutBatch can only be used if every policy is a
+// BatchPayoutPolicy
+func WithPayoutPolicy(policies ...PayoutPolicy) Option {
+	return func(o *options) {
+		o.payoutPolicies = append(o.payoutPolicies, policies...)
+	}
+}
+
+// PolicyRule is the rule a payout violated
+type PolicyRule string
+
+const (
+	PolicyRuleHalted       PolicyRule = "Halted"
+	PolicyRuleDenylist     PolicyRule = "Denylist"
+	PolicyRuleAllowlist    PolicyRule = "Allowlist"
+	PolicyRuleMaxPerPayout PolicyRule = "MaxPerPayout"
+	PolicyRuleMaxPer24h    PolicyRule = "MaxPer24h"
+)
+
+// PolicyViolation is returned when a PayoutPolicy rejects a payout.
+// errors.As also matches an *AkashicError with
+// AkashicErrorCodePolicyViolation
+type PolicyViolation struct {
+	Rule        PolicyRule
+	ReferenceId string
+	Details     string
+}
+
+func (e *PolicyViolation) Error() string {
+	return fmt.Sprintf("payout %v violates policy %v: %v", e.ReferenceId, e.Rule, e.Details)
+}
+
+func (e *PolicyViolation) Unwrap() error {
+	return newAkashicError(AkashicErrorCodePolicyViolation, e.Error())
+}
+
+// evaluatePolicies runs all policies on payout. If one rejects it, the ones
+// that allowed it are released
+func (ap *AkashicPay) evaluatePolicies(ctx context.Context, payout PolicyPayout) error {
+	for i, policy := range ap.payoutPolicies {
+		if err := policy.Evaluate(ctx, payout); err != nil {
+			return errors.Join(err, releasePolicies(ctx, ap.payoutPolicies[:i], payout))
+		}
+	}
+	return nil
+}
+
+// recheckPolicies checks payout again against the policies that support it
+func (ap *AkashicPay) recheckPolicies(ctx context.Context, payout PolicyPayout) error {
+	for _, policy := range ap.payoutPolicies {
+		if recheckPolicy, ok := policy.(RecheckPayoutPolicy); ok {
+			if err := recheckPolicy.Recheck(ctx, payout); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkBatchPolicies checks payouts against all policies without counting
+// them, and returns the violations of all payouts together
+func (ap *AkashicPay) checkBatchPolicies(ctx context.Context, payouts []PolicyPayout) error {
+	violations := make([]error, len(payouts))
+	for _, policy := range ap.payoutPolicies {
+		batchPolicy, ok := policy.(BatchPayoutPolicy)
+		if !ok {
+			return fmt.Errorf("payout policy %T can't check a batch up front", policy)
+		}
+		policyViolations, err := batchPolicy.CheckBatch(ctx, payouts)
+		if err != nil {
+			return err
+		}
+		for i, violation := range policyViolations {
+			// Each payout is reported once, with the first policy rejecting it
+			if violations[i] == nil {
+				violations[i] = violation
+			}
+		}
+	}
+	var errs []error
+	for i, violation := range violations {
+		if violation != nil {
+			errs = append(errs, batchItemError(i, payouts[i].Request, violation))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newPolicyPayout describes the payout of req to recipient for the policies
+func newPolicyPayout(req PayoutRequest, recipient payoutRecipient) PolicyPayout {
+	payout := PolicyPayout{
+		Id:                 rand.Text(),
+		Request:            req,
+		Route:              recipient.Route,
+		RecipientKind:      recipient.Kind,
+		SmallestUnitAmount: recipient.Amount,
+	}
+	if recipient.Route == PayoutRouteL2 {
+		payout.L2Address = recipient.ToAddress
+	}
+	return payout
+}
+
+func releasePolicies(ctx context.Context, policies []PayoutPolicy, payout PolicyPayout) error {
+	var errs []error
+	for _, policy := range policies {
+		errs = append(errs, policy.Release(ctx, payout))
+	}
+	return errors.Join(errs...)
+}
+
+// PolicyAsset is a network and token, zero-valued for the native coin
+type PolicyAsset struct {
+	Network NetworkSymbol
+	Token   TokenSymbol
+}
+
+// PayoutLimits caps payouts of one asset. Amounts are in the main unit, e.g.
+// "1000.5". An empty amount means no cap
+type PayoutLimits struct {
+	MaxPerPayout string // Largest single payout
+	MaxPer24h    string // Largest total of payouts within any 24 hours
+}
+
+// LimitsPolicyConfig configures a LimitsPolicy
+type LimitsPolicyConfig struct {
+	// Caps per asset. Assets without an entry are not capped
+	Limits map[PolicyAsset]PayoutLimits
+	// If set, payouts are only allowed to these receivers. Entries may be
+	// L1 addresses, L2 addresses or aliases, and match the receiver as given
+	// or as resolved
+	Allowlist []string
+	// Payouts to these receivers are rejected. Matched like Allowlist
+	Denylist []string
+	// Start with all payouts rejected, see LimitsPolicy.Halt
+	Halted bool
+	// Where the 24 hour totals are kept. Defaults to memory, which only
+	// covers payouts of this process
+	Counters PolicyCounterStore
+}
+
+// LimitsPolicy is a PayoutPolicy enforcing caps per asset, receiver allow-
+// and denylists and a kill switch
+//
+// Payouts of an asset with a 24 hour cap are checked and counted one at a
+// time, so concurrent payouts can't exceed the cap together. That includes
+// the round trips to the PolicyCounterStore, and only covers this instance
+type LimitsPolicy struct {
+	limits    map[PolicyAsset]parsedPayoutLimits
+	allowlist map[string]bool
+	denylist  map[string]bool
+	counters  PolicyCounterStore
+	// Serialize checking and counting the 24 hour total per asset. Buffered
+	// with one slot, so waiting for them can be cancelled
+	counterLocks map[PolicyAsset]chan struct{}
+
+	mu     sync.Mutex // Guards halted and reason
+	halted bool
+	reason string
+}
+
+type parsedPayoutLimits struct {
+	MaxPerPayout *big.Int // Smallest unit, nil if not capped
+	MaxPer24h    *big.Int
+}
+
+// NewLimitsPolicy returns a LimitsPolicy, or an error if an amount in
+// config is invalid
+func NewLimitsPolicy(config LimitsPolicyConfig) (*LimitsPolicy, error) {
+	p := &LimitsPolicy{
+		limits:       make(map[PolicyAsset]parsedPayoutLimits, len(config.Limits)),
+		allowlist:    make(map[string]bool, len(config.Allowlist)),
+		denylist:     make(map[string]bool, len(config.Denylist)),
+		counters:     config.Counters,
+		counterLocks: make(map[PolicyAsset]chan struct{}),
+		halted:       config.Halted,
+	}
+	if p.counters == nil {
+		p.counters = NewMemoryPolicyCounterStore()
+	}
+	if p.halted {
+		p.reason = "halted by configuration"
+	}
+	for asset, limits := range config.Limits {
+		var parsed parsedPayoutLimits
+		var err error
+		if limits.MaxPerPayout != "" {
+			if parsed.MaxPerPayout, err = smallestUnitInt(limits.MaxPerPayout, asset.Network, asset.Token); err != nil {
+				return nil, fmt.Errorf("MaxPerPayout of %v: %w", currencyName(asset.Network, asset.Token), err)
+			}
+		}
+		if limits.MaxPer24h != "" {
+			if parsed.MaxPer24h, err = smallestUnitInt(limits.MaxPer24h, asset.Network, asset.Token); err != nil {
+				return nil, fmt.Errorf("MaxPer24h of %v: %w", currencyName(asset.Network, asset.Token), err)
+			}
+			p.counterLocks[asset] = make(chan struct{}, 1)
+		}
+		p.limits[asset] = parsed
+	}
+	for _, receiver := range config.Allowlist {
+		p.allowlist[canonicalReceiver(receiver)] = true
+	}
+	for _, receiver := range config.Denylist {
+		p.denylist[canonicalReceiver(receiver)] = true
+	}
+	return p, nil
+}
+
+// Halt rejects all payouts until Resume is called
+func (p *LimitsPolicy) Halt(reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.halted = true
+	p.reason = reason
+}
+
+// Resume allows payouts again after Halt
+func (p *LimitsPolicy) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.halted = false
+	p.reason = ""
+}
+
+// Halted reports whether payouts are halted
+func (p *LimitsPolicy) Halted() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.halted
+}
+
+func (p *LimitsPolicy) Evaluate(ctx context.Context, payout PolicyPayout) error {
+	amount, err := p.check(payout)
+	if err != nil {
+		return err
+	}
+	req := payout.Request
+	asset := PolicyAsset{req.Network, req.Token}
+	limits := p.limits[asset]
+	if limits.MaxPer24h == nil {
+		return nil
+	}
+
+	lock := p.counterLocks[asset]
+	select {
+	case lock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-lock }()
+
+	key := policyCounterKey(asset)
+	now := time.Now()
+	total, err := p.counters.Sum(ctx, key, now.Add(-policyWindow))
+	if err != nil {
+		return err
+	}
+	if err := p.checkMaxPer24h(payout, total, amount, limits.MaxPer24h); err != nil {
+		return err
+	}
+	// Counted right away, so concurrent payouts can't exceed the cap together
+	return p.counters.Add(ctx, key, payout.Id, amount, now)
+}
+
+func (p *LimitsPolicy) Release(ctx context.Context, payout PolicyPayout) error {
+	req := payout.Request
+	asset := PolicyAsset{req.Network, req.Token}
+	if limits, ok := p.limits[asset]; !ok || limits.MaxPer24h == nil {
+		return nil
+	}
+	return p.counters.Remove(ctx, policyCounterKey(asset), payout.Id)
+}
+
+// Recheck applies the rules that don't count payouts: the kill switch, the
+// allow- and denylist and MaxPerPayout
+func (p *LimitsPolicy) Recheck(ctx context.Context, payout PolicyPayout) error {
+	_, err := p.check(payout)
+	return err
+}
+
+// CheckBatch checks payouts like Evaluate would if they were made one after
+// the other, without counting them
+func (p *LimitsPolicy) CheckBatch(ctx context.Context, payouts []PolicyPayout) ([]error, error) {
+	violations := make([]error, len(payouts))
+	totals := make(map[PolicyAsset]*big.Int)
+	since := time.Now().Add(-policyWindow)
+	for i, payout := range payouts {
+		amount, err := p.check(payout)
+		if err != nil {
+			violations[i] = err
+			continue
+		}
+		req := payout.Request
+		asset := PolicyAsset{req.Network, req.Token}
+		limits := p.limits[asset]
+		if limits.MaxPer24h == nil {
+			continue
+		}
+		if totals[asset] == nil {
+			if totals[asset], err = p.counters.Sum(ctx, policyCounterKey(asset), since); err != nil {
+				return nil, err
+			}
+		}
+		if violations[i] = p.checkMaxPer24h(payout, totals[asset], amount, limits.MaxPer24h); violations[i] == nil {
+			totals[asset].Add(totals[asset], amount)
+		}
+	}
+	return violations, nil
+}
+
+// check applies the rules that don't depend on other payouts, and returns
+// the amount of payout in the smallest unit
+func (p *LimitsPolicy) check(payout PolicyPayout) (*big.Int, error) {
+	req := payout.Request
+	violation := func(rule PolicyRule, details string) error {
+		return &PolicyViolation{Rule: rule, ReferenceId: req.ReferenceId, Details: details}
+	}
+
+	p.mu.Lock()
+	halted, reason := p.halted, p.reason
+	p.mu.Unlock()
+	if halted {
+		return nil, violation(PolicyRuleHalted, "payouts are halted: "+reason)
+	}
+
+	receivers := []string{canonicalReceiver(req.To)}
+	if payout.L2Address != "" {
+		receivers = append(receivers, canonicalReceiver(payout.L2Address))
+	}
+	for _, receiver := range receivers {
+		if p.denylist[receiver] {
+			return nil, violation(PolicyRuleDenylist, "receiver is denylisted")
+		}
+	}
+	if len(p.allowlist) > 0 && !slices.ContainsFunc(receivers, func(r string) bool { return p.allowlist[r] }) {
+		return nil, violation(PolicyRuleAllowlist, "receiver is not allowlisted")
+	}
+
+	amount, ok := new(big.Int).SetString(payout.SmallestUnitAmount, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", payout.SmallestUnitAmount)
+	}
+	limits := p.limits[PolicyAsset{req.Network, req.Token}]
+	if limits.MaxPerPayout != nil && amount.Cmp(limits.MaxPerPayout) > 0 {
+		return nil, violation(PolicyRuleMaxPerPayout, fmt.Sprintf("%v exceeds the cap of %v",
+			req.Amount, formatSmallestUnit(limits.MaxPerPayout, req.Network, req.Token)))
+	}
+	return amount, nil
+}
+
+// checkMaxPer24h fails if amount on top of total exceeds maxPer24h
+func (p *LimitsPolicy) checkMaxPer24h(payout PolicyPayout, total *big.Int, amount *big.Int, maxPer24h *big.Int) error {
+	if new(big.Int).Add(total, amount).Cmp(maxPer24h) <= 0 {
+		return nil
+	}
+	req := payout.Request
+	return &PolicyViolation{
+		Rule:        PolicyRuleMaxPer24h,
+		ReferenceId: req.ReferenceId,
+		Details: fmt.Sprintf("%v on top of %v paid out within 24 hours exceeds the cap of %v",
+			req.Amount, formatSmallestUnit(total, req.Network, req.Token), formatSmallestUnit(maxPer24h, req.Network, req.Token)),
+	}
+}
+
+func policyCounterKey(asset PolicyAsset) string {
+	return currencyName(asset.Network, asset.Token)
+}
+
+var l2AddressRegex = regexp.MustCompile(l2RegexWithOptionalPrefix)
+
+// canonicalReceiver returns the form receivers are compared in: L2
+// addresses with the AS prefix and lowercase hex, 0x addresses lowercase,
+// anything else as is
+func canonicalReceiver(receiver string) string {
+	if l2AddressRegex.MatchString(receiver) {
+		return "AS" + strings.ToLower(strings.TrimPrefix(receiver, "AS"))
+	}
+	if strings.HasPrefix(receiver, "0x") {
+		return strings.ToLower(receiver)
+	}
+	return receiver
+}
+
+// PolicyCounterStore keeps the payouts counted towards the rolling caps of
+// a LimitsPolicy. Share one between processes to enforce the caps across
+// them. Implementations must be safe for concurrent use
+type PolicyCounterStore interface {
+	// Add counts amount, in the smallest unit, under key at time at for the
+	// evaluation id, see PolicyPayout.Id
+	Add(ctx context.Context, key string, id string, amount *big.Int, at time.Time) error
+	// Remove uncounts the evaluation id under key, if it is counted
+	Remove(ctx context.Context, key string, id string) error
+	// Sum returns the total counted under key since the given time. Entries
+	// older than since may be discarded
+	Sum(ctx context.Context, key string, since time.Time) (*big.Int, error)
+}
+
+// MemoryPolicyCounterStore is an in-memory PolicyCounterStore
+type MemoryPolicyCounterStore struct {
+	mu      sync.Mutex
+	entries map[string][]policyCounterEntry
+}
+
+type policyCounterEntry struct {
+	Id     string
+	Amount *big.Int
+	At     time.Time
+}
+
+// NewMemoryPolicyCounterStore returns an empty MemoryPolicyCounterStore
+func NewMemoryPolicyCounterStore() *MemoryPolicyCounterStore {
+	return &MemoryPolicyCounterStore{entries: make(map[string][]policyCounterEntry)}
+}
+
+func (s *MemoryPolicyCounterStore) Add(ctx context.Context, key string, id string, amount *big.Int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = append(s.entries[key], policyCounterEntry{id, new(big.Int).Set(amount), at})
+	return nil
+}
+
+func (s *MemoryPolicyCounterStore) Remove(ctx context.Context, key string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = slices.DeleteFunc(s.entries[key], func(entry policyCounterEntry) bool {
+		return entry.Id == id
+	})
+	return nil
+}
+
+func (s *MemoryPolicyCounterStore) Sum(ctx context.Context, key string, since time.Time) (*big.Int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := new(big.Int)
+	kept := s.entries[key][:0]
+	for _, entry := range s.entries[key] {
+		if entry.At.Before(since) {
+			continue
+		}
+		kept = append(kept, entry)
+		total.Add(total, entry.Amount)
+	}
+	s.entries[key] = kept
+	return total, nil
+}
@@ -0,0 +1,312 @@
+package akashicpay
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPolicyPayout(t *testing.T, referenceId string, to string, amount string) PolicyPayout {
+	t.Helper()
+	smallest, err := smallestUnitInt(amount, Tron, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return PolicyPayout{
+		Id:                 rand.Text(),
+		Request:            PayoutRequest{ReferenceId: referenceId, To: to, Amount: amount, Network: Tron},
+		Route:              PayoutRouteL1,
+		RecipientKind:      RecipientL1Address,
+		SmallestUnitAmount: smallest.String(),
+	}
+}
+
+// policyRule returns the rule err violates, or "" if it isn't a violation
+func policyRule(err error) PolicyRule {
+	var violation *PolicyViolation
+	if errors.As(err, &violation) {
+		return violation.Rule
+	}
+	return ""
+}
+
+func TestLimitsPolicyRules(t *testing.T) {
+	const receiver = "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+	const l2Address = "ASabcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"
+
+	tests := []struct {
+		name      string
+		config    LimitsPolicyConfig
+		payout    func(t *testing.T) PolicyPayout
+		wantRule  PolicyRule
+		wantError bool
+	}{
+		{
+			name:   "no rules",
+			payout: func(t *testing.T) PolicyPayout { return newTestPolicyPayout(t, "ref", receiver, "1000") },
+		},
+		{
+			name:     "halted",
+			config:   LimitsPolicyConfig{Halted: true},
+			payout:   func(t *testing.T) PolicyPayout { return newTestPolicyPayout(t, "ref", receiver, "1") },
+			wantRule: PolicyRuleHalted,
+		},
+		{
+			name:     "denylisted receiver",
+			config:   LimitsPolicyConfig{Denylist: []string{receiver}},
+			payout:   func(t *testing.T) PolicyPayout { return newTestPolicyPayout(t, "ref", receiver, "1") },
+			wantRule: PolicyRuleDenylist,
+		},
+		{
+			name: "denylisted resolved L2 address",
+			// Without prefix and in uppercase
+			config: LimitsPolicyConfig{Denylist: []string{strings.ToUpper(l2Address[2:])}},
+			payout: func(t *testing.T) PolicyPayout {
+				payout := newTestPolicyPayout(t, "ref", "alias", "1")
+				payout.Route = PayoutRouteL2
+				payout.L2Address = l2Address
+				return payout
+			},
+			wantRule: PolicyRuleDenylist,
+		},
+		{
+			name:   "allowlisted receiver",
+			config: LimitsPolicyConfig{Allowlist: []string{receiver}},
+			payout: func(t *testing.T) PolicyPayout { return newTestPolicyPayout(t, "ref", receiver, "1") },
+		},
+		{
+			name:     "not allowlisted",
+			config:   LimitsPolicyConfig{Allowlist: []string{l2Address}},
+			payout:   func(t *testing.T) PolicyPayout { return newTestPolicyPayout(t, "ref", receiver, "1") },
+			wantRule: PolicyRuleAllowlist,
+		},
+		{
+			name:   "at MaxPerPayout",
+			config: LimitsPolicyConfig{Limits: map[PolicyAsset]PayoutLimits{{Network: Tron}: {MaxPerPayout: "10.5"}}},
+			payout: func(t *testing.T) PolicyPayout { return newTestPolicyPayout(t, "ref", receiver, "10.5") },
+		},
+		{
+			name:     "above MaxPerPayout",
+			config:   LimitsPolicyConfig{Limits: map[PolicyAsset]PayoutLimits{{Network: Tron}: {MaxPerPayout: "10.5"}}},
+			payout:   func(t *testing.T) PolicyPayout { return newTestPolicyPayout(t, "ref", receiver, "10.500001") },
+			wantRule: PolicyRuleMaxPerPayout,
+		},
+		{
+			name:     "above MaxPer24h",
+			config:   LimitsPolicyConfig{Limits: map[PolicyAsset]PayoutLimits{{Network: Tron}: {MaxPer24h: "10"}}},
+			payout:   func(t *testing.T) PolicyPayout { return newTestPolicyPayout(t, "ref", receiver, "11") },
+			wantRule: PolicyRuleMaxPer24h,
+		},
+		{
+			name:   "other asset not capped",
+			config: LimitsPolicyConfig{Limits: map[PolicyAsset]PayoutLimits{{Network: Tron, Token: USDT}: {MaxPerPayout: "1"}}},
+			payout: func(t *testing.T) PolicyPayout { return newTestPolicyPayout(t, "ref", receiver, "2") },
+		},
+		{
+			name:   "invalid amount",
+			config: LimitsPolicyConfig{Limits: map[PolicyAsset]PayoutLimits{{Network: Tron}: {MaxPerPayout: "1"}}},
+			payout: func(t *testing.T) PolicyPayout {
+				payout := newTestPolicyPayout(t, "ref", receiver, "1")
+				payout.SmallestUnitAmount = "1.5"
+				return payout
+			},
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewLimitsPolicy(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = policy.Evaluate(context.Background(), tt.payout(t))
+			if rule := policyRule(err); rule != tt.wantRule {
+				t.Fatalf("Evaluate() = %v, want rule %q", err, tt.wantRule)
+			}
+			if tt.wantRule == "" && (err != nil) != tt.wantError {
+				t.Fatalf("Evaluate() = %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestLimitsPolicyMaxPer24h(t *testing.T) {
+	ctx := context.Background()
+	counters := NewMemoryPolicyCounterStore()
+	policy, err := NewLimitsPolicy(LimitsPolicyConfig{
+		Limits:   map[PolicyAsset]PayoutLimits{{Network: Tron}: {MaxPer24h: "100"}},
+		Counters: counters,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payout := func(referenceId string, amount string) PolicyPayout {
+		return newTestPolicyPayout(t, referenceId, "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", amount)
+	}
+
+	// Payouts older than 24 hours don't count
+	counters.Add(ctx, policyCounterKey(PolicyAsset{Network: Tron}), "old", big.NewInt(100_000_000), time.Now().Add(-25*time.Hour))
+
+	first := payout("ref-1", "60")
+	second := payout("ref-2", "50")
+	repeated := payout("ref-2", "50")
+	small := payout("ref-3", "0.000001")
+	steps := []struct {
+		action   string // "evaluate" or "release"
+		payout   PolicyPayout
+		wantRule PolicyRule
+	}{
+		{"evaluate", first, ""},
+		{"evaluate", second, PolicyRuleMaxPer24h},
+		// Releasing a payout that was not sent frees its amount
+		{"release", first, ""},
+		{"evaluate", second, ""},
+		// Repeating a payout with the same referenceId counts it again, as
+		// the earlier attempt may still go through
+		{"evaluate", repeated, ""},
+		{"evaluate", small, PolicyRuleMaxPer24h},
+		{"release", repeated, ""},
+		// Releasing is by evaluation, so this doesn't free second's amount
+		{"release", repeated, ""},
+		{"evaluate", small, ""},
+	}
+	for i, step := range steps {
+		var err error
+		if step.action == "release" {
+			err = policy.Release(ctx, step.payout)
+		} else {
+			err = policy.Evaluate(ctx, step.payout)
+		}
+		if rule := policyRule(err); rule != step.wantRule || (rule == "" && err != nil) {
+			t.Fatalf("step %d: %v %v = %v, want rule %q", i, step.action, step.payout.Request.ReferenceId, err, step.wantRule)
+		}
+	}
+
+	total, err := counters.Sum(ctx, policyCounterKey(PolicyAsset{Network: Tron}), time.Now().Add(-policyWindow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := big.NewInt(50_000_001); total.Cmp(want) != 0 {
+		t.Errorf("counted total = %v, want %v", total, want)
+	}
+}
+
+func TestLimitsPolicyHaltAndResume(t *testing.T) {
+	policy, err := NewLimitsPolicy(LimitsPolicyConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payout := newTestPolicyPayout(t, "ref", "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", "1")
+
+	policy.Halt("incident")
+	if !policy.Halted() || policyRule(policy.Evaluate(context.Background(), payout)) != PolicyRuleHalted {
+		t.Fatal("payout allowed while halted")
+	}
+	policy.Resume()
+	if err := policy.Evaluate(context.Background(), payout); policy.Halted() || err != nil {
+		t.Fatalf("Evaluate() after Resume = %v, want nil", err)
+	}
+}
+
+func TestLimitsPolicyWaitIsCancellable(t *testing.T) {
+	policy, err := NewLimitsPolicy(LimitsPolicyConfig{
+		Limits: map[PolicyAsset]PayoutLimits{{Network: Tron}: {MaxPer24h: "100"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another payout of the asset is being counted
+	policy.counterLocks[PolicyAsset{Network: Tron}] <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = policy.Evaluate(ctx, newTestPolicyPayout(t, "ref", "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", "1"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Evaluate() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Payouts of other assets don't wait
+	other := newTestPolicyPayout(t, "ref", "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", "1")
+	other.Request.Token = USDT
+	if err := policy.Evaluate(context.Background(), other); err != nil {
+		t.Fatalf("Evaluate() of other asset = %v, want nil", err)
+	}
+}
+
+func TestLimitsPolicyCheckBatch(t *testing.T) {
+	ctx := context.Background()
+	const receiver = "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+	const denied = "TLa2f6VPqDgRE67v1736s7bJ8Ray5wYjU7"
+	policy, err := NewLimitsPolicy(LimitsPolicyConfig{
+		Limits:   map[PolicyAsset]PayoutLimits{{Network: Tron}: {MaxPerPayout: "50", MaxPer24h: "100"}},
+		Denylist: []string{denied},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Evaluate(ctx, newTestPolicyPayout(t, "earlier", receiver, "30")); err != nil {
+		t.Fatal(err)
+	}
+
+	payouts := []PolicyPayout{
+		newTestPolicyPayout(t, "ref-1", receiver, "40"),
+		newTestPolicyPayout(t, "ref-2", denied, "1"),
+		newTestPolicyPayout(t, "ref-3", receiver, "60"),
+		newTestPolicyPayout(t, "ref-4", receiver, "20"),
+		// 30 earlier, 40 and 20 in the batch
+		newTestPolicyPayout(t, "ref-5", receiver, "20"),
+		newTestPolicyPayout(t, "ref-6", receiver, "10"),
+	}
+	violations, err := policy.CheckBatch(ctx, payouts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PolicyRule{"", PolicyRuleDenylist, PolicyRuleMaxPerPayout, "", PolicyRuleMaxPer24h, ""}
+	for i, violation := range violations {
+		if rule := policyRule(violation); rule != want[i] || (rule == "" && violation != nil) {
+			t.Errorf("payout %d: %v, want rule %q", i, violation, want[i])
+		}
+	}
+
+	// Nothing was counted
+	total, err := policy.counters.Sum(ctx, policyCounterKey(PolicyAsset{Network: Tron}), time.Now().Add(-policyWindow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := big.NewInt(30_000_000); total.Cmp(want) != 0 {
+		t.Errorf("counted total = %v, want %v", total, want)
+	}
+}
+
+type evaluateOnlyPolicy struct{}
+
+func (evaluateOnlyPolicy) Evaluate(ctx context.Context, payout PolicyPayout) error { return nil }
+func (evaluateOnlyPolicy) Release(ctx context.Context, payout PolicyPayout) error  { return nil }
+
+func TestCheckBatchPolicies(t *testing.T) {
+	ctx := context.Background()
+	limits, err := NewLimitsPolicy(LimitsPolicyConfig{Denylist: []string{"denied"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payouts := []PolicyPayout{
+		newTestPolicyPayout(t, "ref-1", "allowed", "1"),
+		newTestPolicyPayout(t, "ref-2", "denied", "1"),
+	}
+
+	ap := &AkashicPay{payoutPolicies: []PayoutPolicy{limits}}
+	err = ap.checkBatchPolicies(ctx, payouts)
+	if policyRule(err) != PolicyRuleDenylist || !strings.Contains(err.Error(), "payout 1") {
+		t.Errorf("checkBatchPolicies() = %v, want payout 1 denylisted", err)
+	}
+
+	// Policies that can't check a batch reject it
+	ap.payoutPolicies = append(ap.payoutPolicies, evaluateOnlyPolicy{})
+	if err := ap.checkBatchPolicies(ctx, payouts[:1]); err == nil {
+		t.Error("checkBatchPolicies() = nil with a policy that can't check batches")
+	}
+}
@@ -0,0 +1,315 @@
+package akashicpay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// A prepared payout with less time than this left before it expires is
+// prepared again before it is submitted
+const preparedPayoutExpiryMargin = 10 * time.Second
+
+// WithTransactionExpiry sets how long payout transactions are valid for,
+// unless PayoutRequest.Expiry is set. Defaults to one minute. Raise it to
+// leave time for approving a PreparedPayout
+func WithTransactionExpiry(expiry time.Duration) Option {
+	return func(o *options) {
+		o.txExpiry = expiry
+	}
+}
+
+// PreparedPayout is a payout that is signed but not yet submitted, see
+// PreparePayout. It can be stored as JSON, e.g. while it awaits approval
+type PreparedPayout struct {
+	Request PayoutRequest
+	// Identity of the instance that signed the payout. Only that identity
+	// can submit it
+	Identity      string
+	Route         PayoutRoute
+	RecipientKind RecipientKind
+	// L2 address the payout is sent to. Empty for L1 payouts
+	L2Address string
+	// Amount in the smallest unit of the currency
+	SmallestUnitAmount string
+	// What the payout policies counted the payout under, see PolicyPayout.Id
+	PolicyId string
+	// Fee delegated to AkashicPay. Only set for L1 payouts prepared by
+	// AkashicScan
+	DelegatedFee string
+	// L1 address the withdrawal is sent from. Only set for L1 payouts
+	// prepared by AkashicScan
+	FromAddress string
+	PreparedAt  time.Time
+	// When the signed transaction stops being accepted. SubmitPayout
+	// prepares the payout again if it is submitted later
+	ExpiresAt time.Time
+	// The signed AkashicChain transaction as JSON. SubmitPayout checks that
+	// it matches the fields above
+	Transaction json.RawMessage
+}
+
+// PreparePayout resolves the receiver of req, checks it against the payout
+// policies and signs the transaction like PayoutWithOptions, but doesn't
+// submit it. Submit it with SubmitPayout, e.g. once it has been approved
+func (ap *AkashicPay) PreparePayout(ctx context.Context, req PayoutRequest) (prepared PreparedPayout, err error) {
+	ctx, end := ap.startOperation(ctx, "PreparePayout")
+	defer func() { end(err) }()
+
+	if err := req.validate(); err != nil {
+		return PreparedPayout{}, err
+	}
+
+	// Whether the BP is an FX BP decides how the transaction is built
+	if err := ap.Connect(ctx); err != nil {
+		return PreparedPayout{}, err
+	}
+
+	if ap.balanceCheck {
+		if err := ap.checkPayoutBalance(ctx, req); err != nil {
+			return PreparedPayout{}, ap.payoutStepFailed(ctx, "balanceCheck", req.ReferenceId, err)
+		}
+	}
+	signed, err := ap.signPayout(ctx, req)
+	if err != nil {
+		return PreparedPayout{}, err
+	}
+	return ap.newPreparedPayout(ctx, req, signed)
+}
+
+// SubmitPayout submits a payout prepared with PreparePayout. If the signed
+// transaction has expired, or is about to, the payout is prepared and
+// signed again first, which checks it against the payout policies again.
+// Otherwise policies that are a RecheckPayoutPolicy check it again, so e.g.
+// halting payouts also stops the prepared ones
+//
+// Idempotency, if enabled with WithIdempotencyStore, applies as for
+// PayoutWithOptions
+func (ap *AkashicPay) SubmitPayout(ctx context.Context, prepared PreparedPayout) (result PayoutResult, err error) {
+	ctx, end := ap.startOperation(ctx, "SubmitPayout")
+	defer func() { end(err) }()
+
+	req := prepared.Request
+	if err := req.validate(); err != nil {
+		return PayoutResult{}, err
+	}
+	if prepared.Identity != ap.otk.Identity {
+		return PayoutResult{}, fmt.Errorf("payout was prepared by %v, not by %v", prepared.Identity, ap.otk.Identity)
+	}
+	if len(prepared.Transaction) == 0 {
+		return PayoutResult{}, errors.New("payout was not prepared with PreparePayout")
+	}
+	signed, err := prepared.signed()
+	if err != nil {
+		return PayoutResult{}, err
+	}
+
+	if err := ap.Connect(ctx); err != nil {
+		return PayoutResult{}, err
+	}
+
+	send := func(beforeSubmit func(payout signedPayout) error) (PayoutResult, error) {
+		return ap.submitPrepared(ctx, prepared, signed, beforeSubmit)
+	}
+	if ap.idempotency != nil {
+		return ap.idempotentPayout(ctx, req, send)
+	}
+	return send(nil)
+}
+
+// submitPrepared submits signed, the transaction of prepared, preparing it
+// again if it has expired
+func (ap *AkashicPay) submitPrepared(ctx context.Context, prepared PreparedPayout, signed signedPayout, beforeSubmit func(payout signedPayout) error) (PayoutResult, error) {
+	req := prepared.Request
+	if ap.balanceCheck {
+		if err := ap.checkPayoutBalance(ctx, req); err != nil {
+			return PayoutResult{}, ap.payoutStepFailed(ctx, "balanceCheck", req.ReferenceId, err)
+		}
+	}
+
+	if time.Until(prepared.ExpiresAt) < preparedPayoutExpiryMargin {
+		ap.logger.InfoContext(ctx, "prepared payout expired, preparing it again",
+			"referenceId", req.ReferenceId,
+			"expiresAt", prepared.ExpiresAt,
+		)
+		// With idempotency the expired transaction provably went nowhere:
+		// either it was never submitted, or idempotentPayout didn't find it.
+		// Otherwise it may have been submitted before, and stays counted
+		if ap.idempotency != nil {
+			if err := releasePolicies(ctx, ap.payoutPolicies, signed.Policy); err != nil {
+				return PayoutResult{}, err
+			}
+		}
+		var err error
+		signed, err = ap.signPayout(ctx, req)
+		if err != nil {
+			return PayoutResult{}, err
+		}
+	} else if err := ap.recheckPolicies(ctx, signed.Policy); err != nil {
+		return PayoutResult{}, ap.payoutStepFailed(ctx, "policy", req.ReferenceId, err)
+	}
+	return ap.sendSignedPayout(ctx, req.ReferenceId, signed, beforeSubmit)
+}
+
+// DiscardPreparedPayout releases what the payout policies counted for a
+// payout prepared with PreparePayout, e.g. once it was rejected. Only
+// discard payouts that were never submitted
+func (ap *AkashicPay) DiscardPreparedPayout(ctx context.Context, prepared PreparedPayout) (err error) {
+	ctx, end := ap.startOperation(ctx, "DiscardPreparedPayout")
+	defer func() { end(err) }()
+
+	if prepared.Identity != ap.otk.Identity {
+		return fmt.Errorf("payout was prepared by %v, not by %v", prepared.Identity, ap.otk.Identity)
+	}
+	return releasePolicies(ctx, ap.payoutPolicies, prepared.policyPayout())
+}
+
+func (ap *AkashicPay) newPreparedPayout(ctx context.Context, req PayoutRequest, signed signedPayout) (PreparedPayout, error) {
+	expiresAt, err := time.Parse(time.RFC3339, signed.Tx.TxObject.Expire)
+	if err != nil {
+		return PreparedPayout{}, errors.Join(
+			fmt.Errorf("invalid transaction expiry %q: %w", signed.Tx.TxObject.Expire, err),
+			releasePolicies(ctx, ap.payoutPolicies, signed.Policy),
+		)
+	}
+	transaction, err := json.Marshal(signed.Tx)
+	if err != nil {
+		return PreparedPayout{}, errors.Join(err, releasePolicies(ctx, ap.payoutPolicies, signed.Policy))
+	}
+	return PreparedPayout{
+		Request:            req,
+		Identity:           ap.otk.Identity,
+		Route:              signed.Result.Route,
+		RecipientKind:      signed.Result.RecipientKind,
+		L2Address:          signed.Result.L2Address,
+		SmallestUnitAmount: signed.Policy.SmallestUnitAmount,
+		PolicyId:           signed.Policy.Id,
+		DelegatedFee:       signed.Result.DelegatedFee,
+		FromAddress:        signed.Result.FromAddress,
+		PreparedAt:         time.Now(),
+		ExpiresAt:          expiresAt,
+		Transaction:        transaction,
+	}, nil
+}
+
+// signed restores the signedPayout prepared was made from, and checks that
+// its transaction matches the other fields of prepared
+func (prepared PreparedPayout) signed() (signedPayout, error) {
+	var tx acTransaction
+	decoder := json.NewDecoder(bytes.NewReader(prepared.Transaction))
+	// Keeps amounts exact
+	decoder.UseNumber()
+	if err := decoder.Decode(&tx); err != nil {
+		return signedPayout{}, fmt.Errorf("invalid prepared transaction: %w", err)
+	}
+	if tx.Signature == nil {
+		return signedPayout{}, errors.New("prepared transaction is not signed")
+	}
+	if err := prepared.checkTransaction(tx); err != nil {
+		return signedPayout{}, err
+	}
+	return signedPayout{
+		Tx: tx,
+		Result: PayoutResult{
+			Route:         prepared.Route,
+			RecipientKind: prepared.RecipientKind,
+			L2Address:     prepared.L2Address,
+			DelegatedFee:  prepared.DelegatedFee,
+			FromAddress:   prepared.FromAddress,
+		},
+		Policy: prepared.policyPayout(),
+	}, nil
+}
+
+// policyPayout returns what the payout policies evaluated for prepared
+func (prepared PreparedPayout) policyPayout() PolicyPayout {
+	return PolicyPayout{
+		Id:                 prepared.PolicyId,
+		Request:            prepared.Request,
+		Route:              prepared.Route,
+		RecipientKind:      prepared.RecipientKind,
+		L2Address:          prepared.L2Address,
+		SmallestUnitAmount: prepared.SmallestUnitAmount,
+	}
+}
+
+// checkTransaction fails unless tx pays the receiver, amount and
+// referenceId prepared describes. Policies, idempotency and approvals are
+// based on those fields, while AkashicChain only sees tx
+func (prepared PreparedPayout) checkTransaction(tx acTransaction) error {
+	req := prepared.Request
+	mismatch := func(field string, got string, want string) error {
+		return fmt.Errorf("prepared transaction doesn't match the payout: %v is %q, expected %q", field, got, want)
+	}
+
+	amount, err := smallestUnitInt(req.Amount, req.Network, req.Token)
+	if err != nil {
+		return err
+	}
+	if amount.String() != prepared.SmallestUnitAmount {
+		return mismatch("SmallestUnitAmount", prepared.SmallestUnitAmount, amount.String())
+	}
+	if referenceId := txField(tx.TxObject.Metadata, "referenceId"); referenceId != req.ReferenceId {
+		return mismatch("referenceId", referenceId, req.ReferenceId)
+	}
+
+	owner, _ := tx.TxObject.Input["owner"].(map[string]any)
+	txAmount := txField(owner, "amount")
+	switch prepared.Route {
+	case PayoutRouteL2:
+		to, _ := tx.TxObject.Output["to"].(map[string]any)
+		if receiver := txField(to, "$stream"); receiver == "" || receiver != prepared.L2Address {
+			return mismatch("receiver", receiver, prepared.L2Address)
+		}
+		// L2 transactions carry the amount in the smallest unit
+		if parsed, ok := new(big.Int).SetString(txAmount, 10); !ok || parsed.Cmp(amount) != 0 {
+			return mismatch("amount", txAmount, amount.String())
+		}
+	case PayoutRouteL1:
+		if receiver := txField(owner, "to"); !sameL1Address(req.Network, receiver, req.To) {
+			return mismatch("receiver", receiver, req.To)
+		}
+		// L1 withdrawals carry the amount in the main unit
+		if parsed, err := smallestUnitInt(txAmount, req.Network, req.Token); err != nil || parsed.Cmp(amount) != 0 {
+			return mismatch("amount", txAmount, req.Amount)
+		}
+	default:
+		return fmt.Errorf("unknown payout route %q", prepared.Route)
+	}
+	return nil
+}
+
+// txField returns the string or number at key of a decoded transaction
+// object, or "" if there is none
+func txField(object map[string]any, key string) string {
+	switch value := object[key].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	default:
+		return ""
+	}
+}
+
+// sameL1Address reports whether a and b are the same address on network,
+// ignoring differences in casing that NormalizeAddress removes, and Tron
+// addresses being in hex
+func sameL1Address(network NetworkSymbol, a string, b string) bool {
+	if a == b {
+		return a != ""
+	}
+	normalize := func(addr string) (string, error) {
+		if addressFamilyOf(network) == addressFamilyTron && isTronHexAddress(addr) {
+			return TronHexToBase58(addr)
+		}
+		return NormalizeAddress(network, addr)
+	}
+	normalizedA, errA := normalize(a)
+	normalizedB, errB := normalize(b)
+	return errA == nil && errB == nil && normalizedA == normalizedB
+}
@@ -0,0 +1,200 @@
+package akashicpay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTronAddress = "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+
+func newTestPreparedPayout(t *testing.T, route PayoutRoute, edit func(tx *acTransaction)) PreparedPayout {
+	t.Helper()
+	envConfig, err := environmentConfig(Development)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := PayoutRequest{ReferenceId: "ref-1", To: testTronAddress, Amount: "1.5", Network: Tron_Shasta, Token: USDT}
+	prepared := PreparedPayout{
+		Request:            req,
+		Identity:           "AS0",
+		Route:              route,
+		SmallestUnitAmount: "1500000",
+		ExpiresAt:          time.Now().Add(time.Minute),
+	}
+
+	tx := l1Transaction(envConfig, "AS0", req.Network, req.Amount, req.To, req.Token, req.ReferenceId, FeeDelegationDelegate, nil, time.Minute)
+	prepared.RecipientKind = RecipientL1Address
+	if route == PayoutRouteL2 {
+		prepared.RecipientKind = RecipientL2Address
+		prepared.L2Address = "AS1"
+		tx.TxObject.Input = map[string]any{"owner": map[string]any{"amount": "1500000"}}
+		tx.TxObject.Output = map[string]any{"to": map[string]any{"$stream": "AS1"}}
+	}
+	if edit != nil {
+		edit(&tx)
+	}
+	prepared.Transaction, err = json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prepared
+}
+
+func TestPreparedPayoutCheckTransaction(t *testing.T) {
+	owner := func(tx *acTransaction) map[string]any {
+		return tx.TxObject.Input["owner"].(map[string]any)
+	}
+
+	tests := []struct {
+		name      string
+		route     PayoutRoute
+		editTx    func(tx *acTransaction)
+		edit      func(prepared *PreparedPayout)
+		wantError string
+	}{
+		{name: "L1", route: PayoutRouteL1},
+		{name: "L2", route: PayoutRouteL2},
+		{
+			name:  "L1 receiver in hex",
+			route: PayoutRouteL1,
+			editTx: func(tx *acTransaction) {
+				owner(tx)["to"] = "41a614f803b6fd780986a42c78ec9c7f77e6ded13c"
+			},
+		},
+		{
+			name:      "L1 receiver changed",
+			route:     PayoutRouteL1,
+			editTx:    func(tx *acTransaction) { owner(tx)["to"] = "TLa2f6VPqDgRE67v1736s7bJ8Ray5wYjU7" },
+			wantError: "receiver",
+		},
+		{
+			name:      "L1 amount changed",
+			route:     PayoutRouteL1,
+			editTx:    func(tx *acTransaction) { owner(tx)["amount"] = "15" },
+			wantError: "amount",
+		},
+		{
+			name:      "L2 receiver changed",
+			route:     PayoutRouteL2,
+			edit:      func(prepared *PreparedPayout) { prepared.L2Address = "AS2" },
+			wantError: "receiver",
+		},
+		{
+			name:      "L2 amount changed",
+			route:     PayoutRouteL2,
+			editTx:    func(tx *acTransaction) { owner(tx)["amount"] = "1500001" },
+			wantError: "amount",
+		},
+		{
+			name:      "referenceId changed",
+			route:     PayoutRouteL1,
+			editTx:    func(tx *acTransaction) { tx.TxObject.Metadata["referenceId"] = "ref-2" },
+			wantError: "referenceId",
+		},
+		{
+			name:  "request amount changed",
+			route: PayoutRouteL1,
+			edit: func(prepared *PreparedPayout) {
+				prepared.Request.Amount = "2"
+			},
+			wantError: "SmallestUnitAmount",
+		},
+		{
+			name:      "not signed",
+			route:     PayoutRouteL1,
+			editTx:    func(tx *acTransaction) { tx.Signature = nil },
+			wantError: "not signed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prepared := newTestPreparedPayout(t, tt.route, tt.editTx)
+			if tt.edit != nil {
+				tt.edit(&prepared)
+			}
+
+			// Prepared payouts are stored as JSON
+			data, err := json.Marshal(prepared)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stored PreparedPayout
+			if err := json.Unmarshal(data, &stored); err != nil {
+				t.Fatal(err)
+			}
+
+			signed, err := stored.signed()
+			if tt.wantError == "" {
+				if err != nil {
+					t.Fatalf("signed() = %v", err)
+				}
+				if signed.Policy.SmallestUnitAmount != "1500000" || signed.Tx.TxObject.Expire == "" {
+					t.Errorf("signed() = %+v, want the prepared payout", signed)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Fatalf("signed() = %v, want error about %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestSubmitPayoutRechecksPolicies(t *testing.T) {
+	var submitted atomic.Bool
+	serverUrl := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			submitted.Store(true)
+		}
+		w.Write([]byte(`{}`))
+	}))
+	policy, err := NewLimitsPolicy(LimitsPolicyConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap := newTestPay(t, serverUrl, WithoutBpCheck(), WithPayoutPolicy(policy))
+	prepared := newTestPreparedPayout(t, PayoutRouteL1, nil)
+	prepared.Identity = ap.otk.Identity
+
+	// Halted after the payout was prepared
+	policy.Halt("incident")
+	if _, err := ap.SubmitPayout(context.Background(), prepared); policyRule(err) != PolicyRuleHalted {
+		t.Fatalf("SubmitPayout() = %v, want rule %v", err, PolicyRuleHalted)
+	}
+	if submitted.Load() {
+		t.Error("halted payout was submitted")
+	}
+}
+
+func TestDiscardPreparedPayout(t *testing.T) {
+	ctx := context.Background()
+	policy, err := NewLimitsPolicy(LimitsPolicyConfig{
+		Limits: map[PolicyAsset]PayoutLimits{{Network: Tron_Shasta, Token: USDT}: {MaxPer24h: "100"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap := newTestPay(t, "", WithoutBpCheck(), WithPayoutPolicy(policy))
+	prepared := newTestPreparedPayout(t, PayoutRouteL1, nil)
+	prepared.Identity = ap.otk.Identity
+	prepared.PolicyId = "evaluation-1"
+	if err := policy.Evaluate(ctx, prepared.policyPayout()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ap.DiscardPreparedPayout(ctx, prepared); err != nil {
+		t.Fatalf("DiscardPreparedPayout() = %v", err)
+	}
+	total, err := policy.counters.Sum(ctx, policyCounterKey(PolicyAsset{Network: Tron_Shasta, Token: USDT}), time.Now().Add(-policyWindow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.Sign() != 0 {
+		t.Errorf("counted total = %v after discarding, want 0", total)
+	}
+}
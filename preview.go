@@ -0,0 +1,148 @@
+package akashicpay
+
+import (
+	"context"
+	"regexp"
+)
+
+// PayoutRoute is how a payout reaches the receiver
+type PayoutRoute string
+
+const (
+	// Transfer between L2 addresses on AkashicChain
+	PayoutRouteL2 PayoutRoute = "L2"
+	// Withdrawal to an address on the L1 network
+	PayoutRouteL1 PayoutRoute = "L1"
+)
+
+// RecipientKind is what the to of a payout was recognized as
+type RecipientKind string
+
+const (
+	RecipientL1Address RecipientKind = "L1Address"
+	RecipientL2Address RecipientKind = "L2Address"
+	RecipientAlias     RecipientKind = "Alias"
+)
+
+// PayoutPreview describes what a payout would do, see PreviewPayout
+type PayoutPreview struct {
+	Route         PayoutRoute
+	RecipientKind RecipientKind
+	// L2 address the payout is sent to. Empty for L1 payouts
+	L2Address string
+	// Amount in the smallest unit of the currency, as sent to AkashicChain
+	SmallestUnitAmount string
+	// Fee delegated to AkashicPay. Only set for L1 payouts
+	DelegatedFee string
+	// L1 address the withdrawal is sent from. Only set for L1 payouts
+	FromAddress string
+}
+
+// payoutRecipient is the resolved receiver of a payout
+type payoutRecipient struct {
+	Kind             RecipientKind
+	Route            PayoutRoute
+	ToAddress        string // L2 address for L2 payouts, the L1 address otherwise
+	InitiatedToNonL2 string // The L1 address or alias the L2 address was looked up by
+	Amount           string // Smallest unit
+}
+
+// PreviewPayout resolves the receiver and route of req like
+// PayoutWithOptions, without signing or submitting anything. For L1 payouts
+// AkashicScan is asked to prepare the withdrawal to learn the delegated fee
+func (ap *AkashicPay) PreviewPayout(ctx context.Context, req PayoutRequest) (preview PayoutPreview, err error) {
+	ctx, end := ap.startOperation(ctx, "PreviewPayout")
+	defer func() { end(err) }()
+
+	if err := req.validate(); err != nil {
+		return PayoutPreview{}, err
+	}
+
+	recipient, err := ap.resolvePayout(ctx, req)
+	if err != nil {
+		return PayoutPreview{}, err
+	}
+	preview = PayoutPreview{
+		Route:              recipient.Route,
+		RecipientKind:      recipient.Kind,
+		SmallestUnitAmount: recipient.Amount,
+	}
+	if recipient.Route == PayoutRouteL2 {
+		preview.L2Address = recipient.ToAddress
+		return preview, nil
+	}
+
+	res, err := prepareL1Txn(ctx, ap.requester, ap.akashicUrl, prepareTxnDto{
+		ToAddress:             req.To,
+		Amount:                req.Amount,
+		NetworkSymbol:         req.Network,
+		TokenSymbol:           req.Token,
+		ReferenceId:           req.ReferenceId,
+		Identity:              ap.otk.Identity,
+		FeeDelegationStrategy: req.feeDelegation(),
+	})
+	if err != nil {
+		return PayoutPreview{}, err
+	}
+	preview.DelegatedFee = res.DelegatedFee
+	preview.FromAddress = res.FromAddress
+	return preview, nil
+}
+
+// resolvePayout decides whether req is paid out on L2 or L1, and to which
+// address
+func (ap *AkashicPay) resolvePayout(ctx context.Context, req PayoutRequest) (payoutRecipient, error) {
+	to := req.To
+	recipient := payoutRecipient{ToAddress: to, Route: PayoutRouteL1}
+
+	amount, err := convertToSmallestUnit(req.Amount, req.Network, req.Token)
+	if err != nil {
+		return payoutRecipient{}, err
+	}
+	recipient.Amount = amount
+
+	InputIsL1, err := regexp.MatchString(networkDictionary[req.Network].AddressRegex, to)
+	if err != nil {
+		return payoutRecipient{}, err
+	}
+	InputIsL2, err := regexp.MatchString(l2RegexWithOptionalPrefix, to)
+	if err != nil {
+		return payoutRecipient{}, err
+	}
+	// The regex lets through typos that the checksum catches
+	if InputIsL1 {
+		if err := ValidateAddress(req.Network, to); err != nil {
+			return payoutRecipient{}, err
+		}
+	}
+
+	L2Lookup, err := ap.LookForL2AddressContext(ctx, to, req.Network)
+	if err != nil {
+		return payoutRecipient{}, ap.payoutStepFailed(ctx, "lookForL2Address", req.ReferenceId, err)
+	}
+
+	if InputIsL1 {
+		recipient.Kind = RecipientL1Address
+		if L2Lookup.L2Address != "" {
+			recipient.ToAddress = L2Lookup.L2Address
+			recipient.InitiatedToNonL2 = to
+			recipient.Route = PayoutRouteL2
+		}
+	} else if InputIsL2 {
+		recipient.Kind = RecipientL2Address
+		if L2Lookup.L2Address == "" {
+			return payoutRecipient{}, newAkashicError(AkashicErrorCodeL2AddressNotFound, "")
+		}
+		recipient.Route = PayoutRouteL2
+	} else {
+		// Must be alias
+		recipient.Kind = RecipientAlias
+		if L2Lookup.L2Address == "" {
+			return payoutRecipient{}, newAkashicError(AkashicErrorCodeL2AddressNotFound, "")
+		}
+		recipient.ToAddress = L2Lookup.L2Address
+		recipient.InitiatedToNonL2 = to
+		recipient.Route = PayoutRouteL2
+	}
+	return recipient, nil
+}
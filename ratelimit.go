@@ -0,0 +1,187 @@
+package akashicpay
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitTarget is an upstream a rate limit applies to
+type RateLimitTarget string
+
+const (
+	RateLimitAkashicScan   RateLimitTarget = "AkashicScan"   // AkashicScan API
+	RateLimitAkashicPayApi RateLimitTarget = "AkashicPayApi" // AkashicPay API
+	RateLimitACNode        RateLimitTarget = "ACNode"        // All AkashicChain nodes and minigates together
+)
+
+// RateLimitPolicy decides what happens to a request that exceeds the limit
+type RateLimitPolicy int
+
+const (
+	// Block until the request is allowed or the context is done
+	RateLimitWait RateLimitPolicy = iota
+	// Fail immediately with AkashicErrorCodeRateLimited
+	RateLimitReject
+)
+
+// RateLimit configures a token bucket that allows Rate requests per second
+// on average and bursts of up to Burst requests
+type RateLimit struct {
+	Rate   float64 // Requests per second
+	Burst  int     // Requests that may be made at once. Defaults to 1
+	Policy RateLimitPolicy
+}
+
+// WithRateLimit limits the requests the instance makes to target. The limit
+// is shared by all goroutines using the instance, and applies to every
+// attempt of a retried request
+func WithRateLimit(target RateLimitTarget, limit RateLimit) Option {
+	return func(o *options) {
+		if o.rateLimits == nil {
+			o.rateLimits = make(map[RateLimitTarget]RateLimit)
+		}
+		o.rateLimits[target] = limit
+	}
+}
+
+// rateLimiter applies a token bucket to every URL starting with one of its
+// prefixes
+type rateLimiter struct {
+	prefixes []string
+	policy   RateLimitPolicy
+	bucket   *tokenBucket
+}
+
+func newRateLimiter(limit RateLimit, prefixes []string) *rateLimiter {
+	return &rateLimiter{
+		prefixes: prefixes,
+		policy:   limit.Policy,
+		bucket:   newTokenBucket(limit.Rate, limit.Burst),
+	}
+}
+
+func (l *rateLimiter) matches(url string) bool {
+	for _, prefix := range l.prefixes {
+		if prefix != "" && strings.HasPrefix(url, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// wait returns once the request may be made, or an error if the policy
+// rejects it or ctx is done first
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.policy == RateLimitReject {
+		if !l.bucket.take() {
+			return newAkashicError(AkashicErrorCodeRateLimited, "")
+		}
+		return nil
+	}
+
+	delay := l.bucket.reserve()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		l.bucket.cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// waitForRateLimit applies the rate limit of the upstream url belongs to,
+// if any
+func (r *requester) waitForRateLimit(ctx context.Context, url string) error {
+	for _, limiter := range r.limiters {
+		if limiter.matches(url) {
+			return limiter.wait(ctx)
+		}
+	}
+	return nil
+}
+
+// tokenBucket holds up to burst tokens and refills rate tokens per second.
+// Safe for concurrent use
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(max(burst, 1))
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// take takes a token if one is available
+func (b *tokenBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes a token, possibly going into debt, and returns how long to
+// wait until it is valid
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	if b.rate <= 0 {
+		// A bucket that never refills only allows its initial burst
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a token taken by reserve that was not used
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+1)
+}
+
+// newRateLimiters creates the limiters configured in limits for the given
+// upstream URLs
+func newRateLimiters(limits map[RateLimitTarget]RateLimit, urls sdkUrls, nodes []acNode) []*rateLimiter {
+	var limiters []*rateLimiter
+	for target, limit := range limits {
+		var prefixes []string
+		switch target {
+		case RateLimitAkashicScan:
+			prefixes = []string{urls.AkashicUrl}
+		case RateLimitAkashicPayApi:
+			prefixes = []string{urls.AkashicPayApiUrl}
+		case RateLimitACNode:
+			for _, node := range nodes {
+				prefixes = append(prefixes, node.Node, node.Minigate)
+			}
+		}
+		limiters = append(limiters, newRateLimiter(limit, prefixes))
+	}
+	return limiters
+}
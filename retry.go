@@ -0,0 +1,125 @@
+package akashicpay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures how requests that fail with a transient error are
+// retried. Transient errors are 429 and 5xx responses (except 501),
+// connection resets and timeouts. The zero value disables retries
+type RetryPolicy struct {
+	MaxAttempts    int           // Total attempts, including the first. Values below 2 disable retries
+	InitialBackoff time.Duration // Backoff before the first retry
+	MaxBackoff     time.Duration // Upper bound for a single backoff, not applied to Retry-After
+	Multiplier     float64       // Growth of the backoff per attempt. Defaults to 2
+	// Longest Retry-After delay that is waited for. If the server asks for a
+	// longer one the error is returned instead. Defaults to MaxBackoff, or
+	// one minute if that is zero
+	MaxRetryAfter time.Duration
+	// Also retry POST requests to AkashicScan and AkashicPay. Transactions
+	// sent to AkashicChain are never retried
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy retries read-only requests up to 3 times in total
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+}
+
+// WithRetryPolicy sets the RetryPolicy of the instance. By default requests
+// are not retried
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = policy
+	}
+}
+
+func (p RetryPolicy) attempts() int {
+	return max(p.MaxAttempts, 1)
+}
+
+// backoff returns how long to wait before the next attempt. It grows
+// exponentially with equal jitter, unless the server asked for a specific
+// delay via Retry-After. Returns false if that delay is too long to wait for
+func (p RetryPolicy) backoff(attempt int, err error) (time.Duration, bool) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		return httpErr.RetryAfter, httpErr.RetryAfter <= p.maxRetryAfter()
+	}
+
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	backoff := float64(p.InitialBackoff)
+	for range attempt - 1 {
+		backoff *= multiplier
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if backoff <= 0 {
+		return 0, true
+	}
+	half := time.Duration(backoff / 2)
+	return half + rand.N(half+1), true
+}
+
+func (p RetryPolicy) maxRetryAfter() time.Duration {
+	switch {
+	case p.MaxRetryAfter > 0:
+		return p.MaxRetryAfter
+	case p.MaxBackoff > 0:
+		return p.MaxBackoff
+	default:
+		return time.Minute
+	}
+}
+
+func isRetryableError(ctx context.Context, err error) bool {
+	// Cancelled or timed out by the caller
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests ||
+			(httpErr.StatusCode >= 500 && httpErr.StatusCode != http.StatusNotImplemented)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package akashicpay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		retryAfter time.Duration
+		wantOk     bool
+	}{
+		{name: "within MaxBackoff", policy: RetryPolicy{MaxBackoff: 5 * time.Second}, retryAfter: 5 * time.Second, wantOk: true},
+		{name: "above MaxBackoff", policy: RetryPolicy{MaxBackoff: 5 * time.Second}, retryAfter: 6 * time.Second},
+		{name: "within MaxRetryAfter", policy: RetryPolicy{MaxBackoff: 5 * time.Second, MaxRetryAfter: time.Minute}, retryAfter: 30 * time.Second, wantOk: true},
+		{name: "above MaxRetryAfter", policy: RetryPolicy{MaxRetryAfter: time.Second}, retryAfter: 2 * time.Second},
+		{name: "default limit", policy: RetryPolicy{}, retryAfter: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backoff, ok := tt.policy.backoff(1, &HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: tt.retryAfter})
+			if ok != tt.wantOk || backoff != tt.retryAfter {
+				t.Errorf("backoff() = %v, %v, want %v, %v", backoff, ok, tt.retryAfter, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestLongRetryAfterNotWaitedFor(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	o := defaultOptions()
+	o.retryPolicy = DefaultRetryPolicy
+	r := newRequester(&o)
+
+	start := time.Now()
+	_, err := get[any](context.Background(), r, server.URL)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("get() = %v, want the 429 response", err)
+	}
+	if requests != 1 || time.Since(start) > time.Second {
+		t.Errorf("sent %d requests in %v, want 1 without waiting", requests, time.Since(start))
+	}
+}
@@ -0,0 +1,137 @@
+package akashicpay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// SubmissionPath selects how transactions are sent to AkashicChain
+type SubmissionPath string
+
+const (
+	// Send transactions straight to the AC node. The default
+	SubmitDirect SubmissionPath = "Direct"
+	// Send transactions to the minigate of the AC node
+	SubmitMinigate SubmissionPath = "Minigate"
+	// Send transactions to the AC node, and to its minigate if the node is
+	// unreachable or refuses the transaction with status 403, 429 or 503
+	SubmitDirectWithMinigateFallback SubmissionPath = "DirectWithMinigateFallback"
+)
+
+// WithSubmissionPath sets how transactions are sent to AkashicChain. Nodes
+// without a minigate are always used directly
+func WithSubmissionPath(path SubmissionPath) Option {
+	return func(o *options) {
+		o.submissionPath = path
+	}
+}
+
+// chainSubmission describes how a transaction reached AkashicChain
+type chainSubmission struct {
+	Node acNode
+	Path SubmissionPath // SubmitDirect or SubmitMinigate
+}
+
+// submitTransaction sends tx to the current AC node, or its minigate. If the
+// node is unavailable the nodes are re-probed so later calls use a healthy
+// one. The transaction itself is only sent again, to the next node, if it
+// provably never reached the first
+func submitTransaction[T any](ctx context.Context, ap *AkashicPay, tx acTransaction) (activeLedgerResponse[T, any], chainSubmission, error) {
+	if err := ap.Connect(ctx); err != nil {
+		return activeLedgerResponse[T, any]{}, chainSubmission{}, err
+	}
+	node, generation := ap.nodes.current()
+	for attempt := 0; ; attempt++ {
+		res, submission, err := sendToNode[T](ctx, ap, node, tx)
+		if err == nil {
+			return res, submission, nil
+		}
+		if ctx.Err() != nil || !nodeUnavailable(err) {
+			return res, submission, err
+		}
+		ap.nodes.reportError(node, err)
+
+		next, nextGeneration, failoverErr := ap.nodes.failover(ctx, node, generation)
+		if failoverErr != nil || !neverReachedNode(err) || attempt+1 >= len(ap.nodes.nodes) {
+			return res, submission, err
+		}
+		node, generation = next, nextGeneration
+	}
+}
+
+// sendToNode sends tx to node on the configured SubmissionPath, falling back
+// to the minigate if allowed
+func sendToNode[T any](ctx context.Context, ap *AkashicPay, node acNode, tx acTransaction) (activeLedgerResponse[T, any], chainSubmission, error) {
+	path := ap.submissionPath
+	if node.Minigate == "" || path == "" {
+		path = SubmitDirect
+	}
+
+	if path == SubmitMinigate {
+		return sendOnPath[T](ctx, ap, node, SubmitMinigate, tx)
+	}
+	res, submission, err := sendOnPath[T](ctx, ap, node, SubmitDirect, tx)
+	if err != nil && path == SubmitDirectWithMinigateFallback && ctx.Err() == nil && nodeRefused(err) {
+		ap.logger.WarnContext(ctx, "falling back to minigate", "node", node.Name, "error", err)
+		return sendOnPath[T](ctx, ap, node, SubmitMinigate, tx)
+	}
+	return res, submission, err
+}
+
+func sendOnPath[T any](ctx context.Context, ap *AkashicPay, node acNode, path SubmissionPath, tx acTransaction) (activeLedgerResponse[T, any], chainSubmission, error) {
+	url := node.Node
+	if path == SubmitMinigate {
+		url = node.Minigate
+	}
+	submission := chainSubmission{Node: node, Path: path}
+
+	ap.logger.DebugContext(ctx, "submitting transaction", "node", node.Name, "path", path, "tx", tx)
+	start := time.Now()
+	res, err := postOnce[activeLedgerResponse[T, any]](ctx, ap.requester, url, tx)
+	ap.instrumentation.ChainSubmit(ctx, ChainSubmitInfo{
+		Node:     url,
+		Path:     path,
+		Contract: tx.TxObject.Contract,
+		Umid:     res.Umid,
+		Commit:   res.Summary.Commit,
+		Vote:     res.Summary.Vote,
+		Total:    res.Summary.Total,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	if err != nil {
+		ap.logger.WarnContext(ctx, "transaction submission failed",
+			"node", node.Name,
+			"path", path,
+			"contract", tx.TxObject.Contract,
+			"error", err,
+		)
+		return res, submission, err
+	}
+	ap.logger.InfoContext(ctx, "submitted transaction",
+		"node", node.Name,
+		"path", path,
+		"umid", res.Umid,
+		"commit", res.Summary.Commit,
+		"vote", res.Summary.Vote,
+		"total", res.Summary.Total,
+		"errors", res.Summary.Errors,
+	)
+	return res, submission, nil
+}
+
+// nodeRefused reports whether the node could not be reached or refused to
+// process the transaction, so it can be sent to the minigate instead
+func nodeRefused(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusForbidden, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return true
+		}
+		return false
+	}
+	return neverReachedNode(err)
+}
@@ -0,0 +1,169 @@
+package akashicpay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Status a testNode never answers transactions with
+const testNodeHang = 1
+
+// testNode is a healthy AC node that answers transactions with status, or
+// accepts them if it is 0
+type testNode struct {
+	*httptest.Server
+	status  atomic.Int32
+	probes  atomic.Int32
+	submits atomic.Int32
+}
+
+func newTestNode(t *testing.T) *testNode {
+	t.Helper()
+	n := &testNode{}
+	n.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			n.probes.Add(1)
+			fmt.Fprintf(w, `{"status": %d}`, acNodeHealthyStatus)
+			return
+		}
+		n.submits.Add(1)
+		switch status := int(n.status.Load()); status {
+		case 0:
+			w.Write([]byte(`{"$umid": "umid", "$summary": {"total": 1, "vote": 1, "commit": 1}}`))
+		case testNodeHang:
+			// The body is read first, so a client that gives up is noticed
+			io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(status)
+		}
+	}))
+	t.Cleanup(n.Close)
+	return n
+}
+
+// newTestNodePay returns an instance connected to two test nodes, and the
+// nodes with the current one first
+func newTestNodePay(t *testing.T, opts ...Option) (*AkashicPay, *testNode, *testNode) {
+	t.Helper()
+	a, b := newTestNode(t), newTestNode(t)
+	opts = append([]Option{
+		WithEnvironmentConfig(EnvironmentConfig{ACNodes: []string{a.URL, b.URL}}),
+		WithoutBpCheck(),
+	}, opts...)
+	ap, err := NewAkashicPayWithOptions(testPrivateKey, testIdentity, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := ap.nodes.current(); current.Node != withTrailingSlash(a.URL) {
+		a, b = b, a
+	}
+	return ap, a, b
+}
+
+func TestSubmitTransactionFailover(t *testing.T) {
+	tests := []struct {
+		name      string
+		breakNode func(node *testNode)
+		// Whether the transaction is sent to the other node
+		wantResent bool
+	}{
+		// The transaction never reached the node
+		{name: "connection refused", breakNode: func(node *testNode) { node.Close() }, wantResent: true},
+		// The node may have processed the transaction
+		{name: "server error", breakNode: func(node *testNode) { node.status.Store(http.StatusInternalServerError) }},
+		{name: "timeout", breakNode: func(node *testNode) { node.status.Store(testNodeHang) }},
+		{name: "bad request", breakNode: func(node *testNode) { node.status.Store(http.StatusBadRequest) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ap, current, other := newTestNodePay(t, WithHTTPClient(&http.Client{Timeout: 200 * time.Millisecond}))
+			tt.breakNode(current)
+
+			_, submission, err := submitTransaction[any](context.Background(), ap, acTransaction{})
+			if tt.wantResent != (err == nil) {
+				t.Fatalf("submitTransaction() = %v, want resent %v", err, tt.wantResent)
+			}
+			if resent := other.submits.Load() > 0; resent != tt.wantResent {
+				t.Errorf("resent = %v, want %v", resent, tt.wantResent)
+			}
+			if tt.wantResent && submission.Node.Node != withTrailingSlash(other.URL) {
+				t.Errorf("submitted to %v, want %v", submission.Node.Node, other.URL)
+			}
+		})
+	}
+}
+
+func TestNodePoolFailoverProbesOnce(t *testing.T) {
+	ap, current, other := newTestNodePay(t)
+	failed, generation := ap.nodes.current()
+	probes := current.probes.Load() + other.probes.Load()
+
+	// Callers that failed on the same ranking share one re-probe
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := ap.nodes.failover(context.Background(), failed, generation); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := current.probes.Load() + other.probes.Load() - probes; got != 2 {
+		t.Errorf("nodes were probed %d times, want once each", got)
+	}
+	if node, _ := ap.nodes.current(); node == failed {
+		t.Errorf("current node is still %v after failover", failed.Node)
+	}
+}
+
+func TestSendToNodeMinigateFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		breakNode    func(node *testNode)
+		wantMinigate bool
+	}{
+		{name: "accepted", breakNode: func(node *testNode) {}},
+		{name: "connection refused", breakNode: func(node *testNode) { node.Close() }, wantMinigate: true},
+		{name: "forbidden", breakNode: func(node *testNode) { node.status.Store(http.StatusForbidden) }, wantMinigate: true},
+		{name: "too many requests", breakNode: func(node *testNode) { node.status.Store(http.StatusTooManyRequests) }, wantMinigate: true},
+		{name: "unavailable", breakNode: func(node *testNode) { node.status.Store(http.StatusServiceUnavailable) }, wantMinigate: true},
+		// The node may have processed the transaction
+		{name: "server error", breakNode: func(node *testNode) { node.status.Store(http.StatusInternalServerError) }},
+		{name: "timeout", breakNode: func(node *testNode) { node.status.Store(testNodeHang) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, minigate := newTestNode(t), newTestNode(t)
+			ap := newTestPay(t, node.URL,
+				WithACNode(node.URL, minigate.URL),
+				WithSubmissionPath(SubmitDirectWithMinigateFallback),
+				WithHTTPClient(&http.Client{Timeout: 200 * time.Millisecond}),
+				WithoutBpCheck(),
+			)
+			tt.breakNode(node)
+
+			_, submission, _ := sendToNode[any](context.Background(), ap, ap.nodes.nodes[0], acTransaction{})
+			if got := minigate.submits.Load() == 1; got != tt.wantMinigate {
+				t.Errorf("sent to minigate = %v, want %v", got, tt.wantMinigate)
+			}
+			wantPath := SubmitDirect
+			if tt.wantMinigate {
+				wantPath = SubmitMinigate
+			}
+			if submission.Path != wantPath {
+				t.Errorf("Path = %v, want %v", submission.Path, wantPath)
+			}
+		})
+	}
+}
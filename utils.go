@@ -28,22 +28,6 @@ type sdkUrls struct {
 	AkashicPayApiUrl string
 }
 
-func getUrls(environment Environment) sdkUrls {
-	if environment == Development {
-		return sdkUrls{
-			AkashicUrl:       akashicBaseUrlDev,
-			AkashicPayUrl:    akashicPayBaseUrlDev,
-			AkashicPayApiUrl: akashicPayApiBaseUrlDev,
-		}
-	}
-
-	return sdkUrls{
-		AkashicUrl:       akashicBaseUrl,
-		AkashicPayUrl:    akashicPayBaseUrl,
-		AkashicPayApiUrl: akashicPayApiBaseUrl,
-	}
-}
-
 // Ensures a UMID/L2-address has exactly one AS prefix. i.e. it is idempotent.
 // returns error if the umid argument isn't a valid UMID/L2-address with or
 // without the prefix